@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// Arpeggio shapes selected with "{arp=...}" on "p" channels
+const (
+	ArpUp     = "up"     // ascending (Ruby behaviour, default)
+	ArpDown   = "down"   // descending
+	ArpUpDown = "updown" // ascending then descending, without repeating the ends
+	ArpDownUp = "downup" // descending then ascending, without repeating the ends
+	ArpRandom = "random" // shuffled with ArpSeed, so equal chords give equal ornaments
+	ArpChord  = "chord"  // every chord tone alternates with the doubled root
+)
+
+// arpeggioSteps orders ascending chord offsets into the steps of one loop
+func arpeggioSteps(offsets []int, shape string, seed int64) []int {
+	steps := make([]int, 0, len(offsets)*2)
+	switch shape {
+	case ArpDown:
+		for i := len(offsets) - 1; i >= 0; i-- {
+			steps = append(steps, offsets[i])
+		}
+	case ArpUpDown:
+		steps = append(steps, offsets...)
+		for i := len(offsets) - 2; i > 0; i-- {
+			steps = append(steps, offsets[i])
+		}
+	case ArpDownUp:
+		for i := len(offsets) - 1; i >= 0; i-- {
+			steps = append(steps, offsets[i])
+		}
+		steps = append(steps, offsets[1:len(offsets)-1]...)
+	case ArpRandom:
+		steps = append(steps, offsets...)
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(steps), func(i, j int) {
+			steps[i], steps[j] = steps[j], steps[i]
+		})
+	case ArpChord:
+		for _, offset := range offsets[1:] {
+			steps = append(steps, offsets[0], offset)
+		}
+	default:
+		steps = append(steps, offsets...)
+	}
+	return steps
+}
+
+// arpeggioString creates the ornament for chord offsets using the channel's
+// arpeggio shape, rate in frames per step and loop step
+func (og *OrnamentGenerator) arpeggioString(offsets []int, setting *ChannelSettings) string {
+	if len(offsets) <= 1 {
+		return og.createOrnamentString(offsets)
+	}
+
+	rate := setting.ArpRate
+	if rate == 0 {
+		rate = og.config.OrnRepeat
+	}
+	steps := arpeggioSteps(offsets, setting.Arp, setting.ArpSeed)
+
+	var values []int
+	for _, step := range steps {
+		for i := 0; i < rate; i++ {
+			values = append(values, step)
+		}
+	}
+
+	loop := setting.ArpLoop
+	if loop >= len(steps) {
+		loop = len(steps) - 1
+	}
+	return ornamentKey(values, loop*rate)
+}
+
+// parseArpOption applies one of the arp, rate, loop and seed options
+func parseArpOption(setting *ChannelSettings, name, value string) error {
+	if setting.InstrumentType != "p" {
+		return fmt.Errorf("option '%s' is only valid on 'p' channels", name)
+	}
+	if name == "arp" {
+		switch value {
+		case ArpUp, ArpDown, ArpUpDown, ArpDownUp, ArpRandom, ArpChord:
+			setting.Arp = value
+			return nil
+		}
+		return fmt.Errorf("unknown arpeggio '%s' (expected up, down, updown, downup, random or chord)", value)
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("option '%s' expects a number, got '%s'", name, value)
+	}
+	switch name {
+	case "rate":
+		if n < 1 || n > 16 {
+			return fmt.Errorf("rate %d out of range 1-16 frames", n)
+		}
+		setting.ArpRate = n
+	case "loop":
+		if n < 0 {
+			return fmt.Errorf("loop step %d must not be negative", n)
+		}
+		setting.ArpLoop = n
+	case "seed":
+		setting.ArpSeed = int64(n)
+	}
+	return nil
+}
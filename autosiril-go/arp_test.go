@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestArpeggioSteps(t *testing.T) {
+	tests := []struct {
+		offsets []int
+		shape   string
+		want    string
+	}{
+		{[]int{0, 4, 7}, "", "[0 4 7]"},
+		{[]int{0, 4, 7}, ArpUp, "[0 4 7]"},
+		{[]int{0, 4, 7}, ArpDown, "[7 4 0]"},
+		{[]int{0, 4, 7, 12}, ArpUpDown, "[0 4 7 12 7 4]"},
+		{[]int{0, 4, 7, 12}, ArpDownUp, "[12 7 4 0 4 7]"},
+		{[]int{0, 4, 7}, ArpChord, "[0 4 0 7]"},
+		{[]int{0, 7}, ArpUpDown, "[0 7]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(arpeggioSteps(tt.offsets, tt.shape, 0)); got != tt.want {
+			t.Errorf("arpeggioSteps(%v, %q) = %s, want %s", tt.offsets, tt.shape, got, tt.want)
+		}
+	}
+
+	// Random keeps the chord tones and is stable for a seed
+	first := fmt.Sprint(arpeggioSteps([]int{0, 3, 7, 10}, ArpRandom, 42))
+	if again := fmt.Sprint(arpeggioSteps([]int{0, 3, 7, 10}, ArpRandom, 42)); again != first {
+		t.Errorf("random with one seed gave %s and %s", first, again)
+	}
+	if got := fmt.Sprint(pitchClasses(arpeggioSteps([]int{0, 3, 7, 10}, ArpRandom, 42))); got != "[0 3 7 10]" {
+		t.Errorf("random steps lost chord tones: %s", got)
+	}
+}
+
+func TestArpeggioString(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"1p", "L0,4,7"},
+		{"1p{arp=down}", "L7,4,0"},
+		{"1p{arp=updown,rate=2}", "L0,0,4,4,7,7,4,4"},
+		{"1p{loop=1}", "0,L4,7"},
+		{"1p{rate=2,loop=9}", "0,0,4,4,L7,7"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		og := NewOrnamentGenerator(newTestConfig(t))
+		if got := og.arpeggioString([]int{0, 4, 7}, &channels[0][0]); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.mapping, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// framesPerRow matches the Speed written to the module header
+const framesPerRow = 4
+
+// collapseArpeggios replaces runs of short notes that cycle through a small
+// set of pitches with one held note carrying a generated ornament. A run
+// needs at least ArpMinNotes notes of at most ArpMaxLen rows, each starting
+// when the previous one ends, with 2..ArpMaxTones distinct pitches within
+// MaxOffset semitones, each played at least twice.
+func (pp *PolyphonicProcessor) collapseArpeggios(notes []*VirtualNote, ornamentGen *OrnamentGenerator) []*VirtualNote {
+	sorted := make([]*VirtualNote, len(notes))
+	copy(sorted, notes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	var result []*VirtualNote
+	collapsed, runs := 0, 0
+	for i := 0; i < len(sorted); {
+		end := pp.arpeggioRunEnd(sorted, i)
+		if end-i < pp.config.ArpMinNotes || !isRepeatingRun(sorted[i:end]) {
+			result = append(result, sorted[i])
+			i++
+			continue
+		}
+
+		result = append(result, pp.arpeggioNote(sorted[i:end], ornamentGen))
+		collapsed += end - i
+		runs++
+		i = end
+	}
+
+	if runs > 0 {
+		pp.config.logf("Collapsed %d notes into %d arpeggio ornaments\n", collapsed, runs)
+	}
+	return result
+}
+
+// arpeggioRunEnd returns the index after the longest run starting at start
+func (pp *PolyphonicProcessor) arpeggioRunEnd(notes []*VirtualNote, start int) int {
+	if notes[start].Length > pp.config.ArpMaxLen {
+		return start + 1
+	}
+	low, high := notes[start].Note, notes[start].Note
+	pitches := map[int]bool{notes[start].Note: true}
+
+	end := start + 1
+	for ; end < len(notes); end++ {
+		note, prev := notes[end], notes[end-1]
+		if note.Length > pp.config.ArpMaxLen || note.Start != prev.Off {
+			break
+		}
+		if !pitches[note.Note] {
+			if len(pitches) == pp.config.ArpMaxTones {
+				break
+			}
+			newLow, newHigh := min(low, note.Note), max(high, note.Note)
+			if newHigh-newLow > pp.config.MaxOffset {
+				break
+			}
+			low, high = newLow, newHigh
+			pitches[note.Note] = true
+		}
+	}
+	return end
+}
+
+// isRepeatingRun reports whether every pitch of the run is played at least twice
+func isRepeatingRun(run []*VirtualNote) bool {
+	counts := make(map[int]int)
+	for _, note := range run {
+		counts[note.Note]++
+	}
+	if len(counts) < 2 {
+		return false
+	}
+	for _, count := range counts {
+		if count < 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// arpeggioNote builds the held note for a run. The ornament plays the
+// shortest repeating cycle of the run in the order it was played.
+func (pp *PolyphonicProcessor) arpeggioNote(run []*VirtualNote, ornamentGen *OrnamentGenerator) *VirtualNote {
+	base := run[0].Note
+	for _, note := range run {
+		base = min(base, note.Note)
+	}
+
+	period := len(run)
+	for p := 2; p < len(run); p++ {
+		repeats := true
+		for i := p; i < len(run); i++ {
+			if run[i].Note != run[i-p].Note {
+				repeats = false
+				break
+			}
+		}
+		if repeats {
+			period = p
+			break
+		}
+	}
+
+	steps := make([]int, period)
+	for i := range steps {
+		steps[i] = run[i].Note - base
+	}
+
+	first, last := run[0], run[len(run)-1]
+	rate := framesPerRow * (last.Off - first.Start) / len(run)
+	if rate < 1 {
+		rate = 1
+	}
+
+	return &VirtualNote{
+		Note:     base,
+		Volume:   first.Volume,
+		Velocity: first.Velocity,
+		Start:    first.Start,
+		Off:      last.Off,
+		Length:   last.Off - first.Start,
+		Channel:  first.Channel,
+		Tick:     first.Tick,
+		Settings: fmt.Sprintf("ornament:%d", ornamentGen.SequenceOrnament(steps, rate)),
+		Merged:   run,
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// runNotes builds one-row notes played back to back from row start
+func runNotes(start int, pitches ...int) []*VirtualNote {
+	var notes []*VirtualNote
+	for i, pitch := range pitches {
+		notes = append(notes, &VirtualNote{Note: pitch, Volume: 15, Start: start + i, Off: start + i + 1, Length: 1, Channel: 1})
+	}
+	return notes
+}
+
+func TestCollapseArpeggios(t *testing.T) {
+	long := &VirtualNote{Note: 72, Volume: 15, Start: 20, Off: 24, Length: 4, Channel: 1}
+	tests := []struct {
+		name  string
+		args  []string
+		notes []*VirtualNote
+		want  string
+	}{
+		{"major triad", nil, runNotes(0, 60, 64, 67, 60, 64, 67), "60@0-6:L0,0,0,0,4,4,4,4,7,7,7,7"},
+		{"two tones", nil, runNotes(2, 62, 69, 62, 69), "62@2-6:L0,0,0,0,7,7,7,7"},
+		{"scale", nil, runNotes(0, 60, 62, 64, 65), "60@0-1 62@1-2 64@2-3 65@3-4"},
+		{"too short", nil, runNotes(0, 60, 64, 60), "60@0-1 64@1-2 60@2-3"},
+		{"long note ends run", nil, append(runNotes(16, 60, 64, 60, 64), long), "60@16-20:L0,0,0,0,4,4,4,4 72@20-24"},
+		{"too wide", []string{"in.mid", "1m", "4", "3", "6", "0", "0", "1", "5"}, runNotes(0, 60, 67, 60, 67), "60@0-1 67@1-2 60@2-3 67@3-4"},
+		{"min notes", []string{"--arp-min-notes=6"}, runNotes(0, 60, 64, 60, 64), "60@0-1 64@1-2 60@2-3 64@3-4"},
+	}
+	for _, tt := range tests {
+		config := newTestConfig(t, tt.args...)
+		og := NewOrnamentGenerator(config)
+		collapsed := NewPolyphonicProcessor(config).collapseArpeggios(tt.notes, og)
+		patterns := make(map[string]string)
+		for key, id := range og.GetOrnaments() {
+			patterns[fmt.Sprintf("ornament:%d", id)] = key
+		}
+		var got []string
+		for _, note := range collapsed {
+			text := fmt.Sprintf("%d@%d-%d", note.Note, note.Start, note.Off)
+			if note.Settings != "" {
+				text += ":" + patterns[note.Settings]
+			}
+			got = append(got, text)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, strings.Join(got, " "), tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DrumKitAttacks are the pitch drops used with "{attack=kit}" on "d" channels
+var DrumKitAttacks = map[string][]int{
+	DrumKick:  {24, 12, 5, 0},
+	DrumSnare: {12, 0},
+	DrumTom:   {12, 7, 3, 0},
+}
+
+// AttackOrnament returns a one-shot ornament playing the offsets for one
+// frame each and staying on the last one
+func (og *OrnamentGenerator) AttackOrnament(offsets []int) int {
+	return og.register(ornamentKey(offsets, len(offsets)-1))
+}
+
+// noteAttack returns the attack offsets for a note start, or nil. Drum
+// channels look the note's class up first and fall back to the "" entry.
+func noteAttack(note int, setting *ChannelSettings) []int {
+	if setting.InstrumentType == "d" {
+		if offsets, ok := setting.Attacks[drumClass(note)]; ok {
+			return offsets
+		}
+	}
+	return setting.Attacks[""]
+}
+
+// parseAttackOption parses "12.7.0" for every note, and on "d" channels
+// "kit" and "class:offsets" parts separated by "/", later parts overriding
+func parseAttackOption(setting *ChannelSettings, value string) error {
+	if setting.InstrumentType == "p" {
+		return fmt.Errorf("option 'attack' is not valid on 'p' channels")
+	}
+	attacks := make(map[string][]int)
+	for _, part := range strings.Split(value, "/") {
+		class, offsets := "", part
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			class, offsets = part[:i], part[i+1:]
+		}
+		if setting.InstrumentType != "d" && (class != "" || part == "kit") {
+			return fmt.Errorf("drum attacks '%s' are only valid on 'd' channels", part)
+		}
+		if part == "kit" {
+			for c, kit := range DrumKitAttacks {
+				attacks[c] = kit
+			}
+			continue
+		}
+		if class != "" && !isDrumClass(class) {
+			return fmt.Errorf("unknown drum class '%s' (expected %s)", class, strings.Join(DefaultDrumPriority, ", "))
+		}
+		values, err := parseAttackOffsets(offsets)
+		if err != nil {
+			return err
+		}
+		attacks[class] = values
+	}
+	setting.Attacks = attacks
+	return nil
+}
+
+// parseAttackOffsets parses dot-separated semitone offsets
+func parseAttackOffsets(value string) ([]int, error) {
+	var offsets []int
+	for _, field := range strings.Split(value, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("attack expects dot-separated offsets like 12.7.0, got '%s'", value)
+		}
+		if n < -48 || n > 48 {
+			return nil, fmt.Errorf("attack offset %d out of range -48..48", n)
+		}
+		offsets = append(offsets, n)
+	}
+	return offsets, nil
+}
+
+func isDrumClass(class string) bool {
+	for _, c := range DefaultDrumPriority {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNoteAttack(t *testing.T) {
+	tests := []struct {
+		mapping string
+		note    int
+		want    string
+	}{
+		{"1m", 60, "[]"},
+		{"1m{attack=12.7.0}", 60, "[12 7 0]"},
+		{"1d{attack=kit}", 36, "[24 12 5 0]"},
+		{"1d{attack=kit}", 42, "[]"},
+		{"1d{attack=kit/hat:5.0}", 42, "[5 0]"},
+		{"1d{attack=kit/kick:-3.0}", 36, "[-3 0]"},
+		{"1d{attack=7.0/snare:12.0}", 38, "[12 0]"},
+		{"1d{attack=7.0/snare:12.0}", 56, "[7 0]"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		if got := fmt.Sprint(noteAttack(tt.note, &channels[0][0])); got != tt.want {
+			t.Errorf("%q note %d: got %s, want %s", tt.mapping, tt.note, got, tt.want)
+		}
+	}
+}
+
+func TestAttackOrnament(t *testing.T) {
+	og := NewOrnamentGenerator(newTestConfig(t))
+	id := og.AttackOrnament([]int{12, 7, 0})
+	if again := og.AttackOrnament([]int{12, 7, 0}); again != id {
+		t.Errorf("equal attacks got ornaments %d and %d", id, again)
+	}
+	for key, ornID := range og.GetOrnaments() {
+		if ornID == id && key != "12,7,L0" {
+			t.Errorf("attack ornament %d = %s, want 12,7,L0", id, key)
+		}
+	}
+	if chord := og.SequenceOrnament([]int{12, 7, 0}, 1); chord == id {
+		t.Errorf("looping chord shares the one-shot ornament %d", id)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxOrnaments is the number of ornament slots VT2 offers besides ornament 0
+const MaxOrnaments = 15
+
+// ornamentUsage collects how an ornament is used on the timelines
+type ornamentUsage struct {
+	id      int
+	key     string
+	rows    int
+	fixed   bool // the base note cannot move (held chords, grace ornaments)
+	classes []int
+}
+
+// ornamentSubstitution replaces one ornament with another and moves the base note
+type ornamentSubstitution struct {
+	to    int
+	shift int
+}
+
+// settingsOrnament extracts the ornament stored in a note's Settings
+func settingsOrnament(settings string) (int, bool) {
+	if !strings.HasPrefix(settings, "ornament:") {
+		return 0, false
+	}
+	ornNum, err := strconv.Atoi(strings.TrimPrefix(settings, "ornament:"))
+	if err != nil {
+		return 0, false
+	}
+	return ornNum, true
+}
+
+// applyBudget keeps the generated ornaments within VT2's slots. Ornaments are
+// ranked by the rows they sound on; the most used ones keep (or get) a slot
+// and the rest are replaced by the kept ornament with the most similar pitch
+// classes, moving the base note when that folds an inversion.
+func (og *OrnamentGenerator) applyBudget(timelines [][]*TimelineNote) {
+	usage := make(map[int]*ornamentUsage)
+	for key, id := range og.ornaments {
+		if id != 0 {
+			values, loop := parseOrnamentKey(key)
+			usage[id] = &ornamentUsage{id: id, key: key, classes: pitchClasses(values), fixed: loop != 0}
+		}
+	}
+	for _, timeline := range timelines {
+		var start *ornamentUsage // ornament of the note the "o" cells are played over
+		for _, note := range timeline {
+			ornNum, ok := settingsOrnament(note.Settings)
+			u := usage[ornNum]
+			if note.Type == "s" {
+				start = u
+			}
+			if !ok || u == nil {
+				continue
+			}
+			u.rows++
+			if note.Type == "o" {
+				u.fixed = true
+				if start != nil {
+					start.fixed = true
+				}
+			}
+		}
+	}
+
+	var ranked []*ornamentUsage
+	for _, u := range usage {
+		if u.rows > 0 {
+			ranked = append(ranked, u)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].rows != ranked[j].rows {
+			return ranked[i].rows > ranked[j].rows
+		}
+		return ranked[i].id < ranked[j].id
+	})
+
+	kept := ranked
+	var dropped []*ornamentUsage
+	if len(ranked) > MaxOrnaments {
+		kept, dropped = ranked[:MaxOrnaments], ranked[MaxOrnaments:]
+	}
+
+	// Kept ornaments above the slot range move into free slots
+	substitutions := make(map[int]ornamentSubstitution)
+	used := make(map[int]bool)
+	for _, u := range kept {
+		if u.id <= MaxOrnaments {
+			used[u.id] = true
+		}
+	}
+	for _, u := range kept {
+		if u.id > MaxOrnaments {
+			free := og.freeSlot(used)
+			used[free] = true
+			substitutions[u.id] = ornamentSubstitution{to: free}
+			note := ""
+			if og.seeded[free] {
+				note = ", taking the slot of an unused seeded ornament"
+			}
+			og.config.logf("ornament budget: ornament %d (%s) renumbered to %d%s\n", u.id, u.key, free, note)
+		}
+	}
+
+	for _, u := range dropped {
+		sub, target := og.nearestOrnament(u, kept)
+		to := 0
+		if target != nil {
+			to = target.id
+			if s, moved := substitutions[target.id]; moved {
+				to = s.to
+			}
+		}
+		substitutions[u.id] = ornamentSubstitution{to: to, shift: sub.shift}
+		targetKey := og.zeroKey()
+		if target != nil {
+			targetKey = target.key
+		}
+		og.config.logf("ornament budget: ornament %d (%s, %d rows) replaced by %d (%s), base shift %+d\n",
+			u.id, u.key, u.rows, to, targetKey, sub.shift)
+	}
+
+	// Forget ornaments that are not used or were replaced, renumber the rest.
+	// Unused seeded ornaments stay in the table while their slot is free.
+	isDropped := make(map[int]bool)
+	for _, u := range dropped {
+		isDropped[u.id] = true
+	}
+	for key, id := range og.ornaments {
+		if id == 0 {
+			continue
+		}
+		u := usage[id]
+		if u != nil && u.rows == 0 && og.seeded[id] && !used[id] {
+			continue
+		}
+		if u == nil || u.rows == 0 || isDropped[id] {
+			delete(og.ornaments, key)
+		} else if sub, ok := substitutions[id]; ok {
+			og.ornaments[key] = sub.to
+		}
+	}
+	if len(substitutions) == 0 {
+		return
+	}
+
+	for _, timeline := range timelines {
+		for _, note := range timeline {
+			ornNum, ok := settingsOrnament(note.Settings)
+			if !ok {
+				continue
+			}
+			sub, ok := substitutions[ornNum]
+			if !ok {
+				continue
+			}
+			note.Settings = fmt.Sprintf("ornament:%d", sub.to)
+			if sub.shift != 0 {
+				note.Note += sub.shift
+				note.calculatePitchOctave()
+			}
+		}
+	}
+}
+
+func (og *OrnamentGenerator) zeroKey() string {
+	return og.createOrnamentString([]int{0})
+}
+
+// nearestOrnament finds the kept ornament whose pitch classes differ least
+// from u, trying every transposition unless u's base note is fixed. A nil
+// target means the plain note (ornament 0) is the best match.
+func (og *OrnamentGenerator) nearestOrnament(u *ornamentUsage, kept []*ornamentUsage) (ornamentSubstitution, *ornamentUsage) {
+	best := ornamentSubstitution{}
+	var bestTarget *ornamentUsage
+	bestDist := classDistance(u.classes, []int{0}, 0)
+
+	for _, candidate := range kept {
+		for shift := -5; shift <= 6; shift++ {
+			if shift != 0 && u.fixed {
+				continue
+			}
+			// The candidate played from base+shift must match u played from base
+			dist := classDistance(u.classes, candidate.classes, shift)
+			if dist < bestDist || (dist == bestDist && bestTarget != nil && abs(shift) < abs(best.shift)) {
+				bestDist = dist
+				best = ornamentSubstitution{to: candidate.id, shift: shift}
+				bestTarget = candidate
+			}
+		}
+	}
+	return best, bestTarget
+}
+
+// pitchClasses folds ornament offsets into sorted unique pitch classes
+func pitchClasses(values []int) []int {
+	seen := make(map[int]bool)
+	var classes []int
+	for _, v := range values {
+		c := ((v % 12) + 12) % 12
+		if !seen[c] {
+			seen[c] = true
+			classes = append(classes, c)
+		}
+	}
+	sort.Ints(classes)
+	return classes
+}
+
+// classDistance counts the pitch classes not shared by a and b shifted by shift
+func classDistance(a, b []int, shift int) int {
+	inA := make(map[int]bool)
+	for _, c := range a {
+		inA[c] = true
+	}
+	dist := 0
+	inB := make(map[int]bool)
+	for _, c := range b {
+		shifted := ((c+shift)%12 + 12) % 12
+		inB[shifted] = true
+		if !inA[shifted] {
+			dist++
+		}
+	}
+	for _, c := range a {
+		if !inB[c] {
+			dist++
+		}
+	}
+	return dist
+}
+
+// AddOrnament registers an ornament after the budget was applied. It only
+// takes a free slot and reports false when all slots are in use.
+func (og *OrnamentGenerator) AddOrnament(key string) (int, bool) {
+	if id, exists := og.ornaments[key]; exists {
+		return id, id <= MaxOrnaments
+	}
+	used := make(map[int]bool)
+	for _, id := range og.ornaments {
+		used[id] = true
+	}
+	for id := 1; id <= MaxOrnaments; id++ {
+		if !used[id] {
+			og.ornaments[key] = id
+			og.config.logf("Created ornament %d: %s\n", id, key)
+			return id, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// budgetGenerator registers 17 chord ornaments: the intervals 1-11 as ids
+// 1-11, then major, minor, augmented, diminished, major 7th and a major
+// chord in first inversion as ids 12-17
+func budgetGenerator(t *testing.T) *OrnamentGenerator {
+	t.Helper()
+	og := NewOrnamentGenerator(newTestConfig(t))
+	for k := 1; k <= 11; k++ {
+		og.register(og.createOrnamentString([]int{0, k}))
+	}
+	for _, chord := range [][]int{{0, 4, 7}, {0, 3, 7}, {0, 4, 8}, {0, 3, 6}, {0, 4, 7, 11}, {0, 3, 8}} {
+		og.register(og.createOrnamentString(chord))
+	}
+	return og
+}
+
+// budgetTimeline plays ornaments 1-15 on three rows each and the given
+// cells after them
+func budgetTimeline(extra ...*TimelineNote) []*TimelineNote {
+	var timeline []*TimelineNote
+	for id := 1; id <= 15; id++ {
+		for i := 0; i < 3; i++ {
+			note := NewTimelineNote(48, 15, "s")
+			note.Settings = fmt.Sprintf("ornament:%d", id)
+			timeline = append(timeline, note)
+		}
+	}
+	return append(timeline, extra...)
+}
+
+func budgetNote(noteType string, ornament int) *TimelineNote {
+	note := NewTimelineNote(48, 15, noteType)
+	note.Settings = fmt.Sprintf("ornament:%d", ornament)
+	return note
+}
+
+func TestApplyBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		extra    []*TimelineNote
+		settings []string
+		notes    []int
+	}{
+		{"drop the 7th", []*TimelineNote{budgetNote("s", 16)}, []string{"ornament:12"}, []int{48}},
+		{"fold the inversion", []*TimelineNote{budgetNote("s", 17)}, []string{"ornament:12"}, []int{44}},
+		{"held base stays", []*TimelineNote{budgetNote("s", 17), budgetNote("o", 1)}, []string{"ornament:3", "ornament:1"}, []int{48, 48}},
+	}
+	for _, tt := range tests {
+		og := budgetGenerator(t)
+		timeline := budgetTimeline(tt.extra...)
+		ornaments := og.GenerateOrnaments([][]*TimelineNote{timeline})
+		if len(ornaments) > MaxOrnaments {
+			t.Errorf("%s: %d ornaments, want at most %d", tt.name, len(ornaments), MaxOrnaments)
+		}
+		for _, orn := range ornaments {
+			if orn.ID < 1 || orn.ID > MaxOrnaments {
+				t.Errorf("%s: ornament id %d out of range", tt.name, orn.ID)
+			}
+		}
+		for i, cell := range timeline[45:] {
+			if cell.Settings != tt.settings[i] || cell.Note != tt.notes[i] {
+				t.Errorf("%s: cell %d = %s note %d, want %s note %d", tt.name, i, cell.Settings, cell.Note, tt.settings[i], tt.notes[i])
+			}
+		}
+	}
+}
+
+func TestClassDistance(t *testing.T) {
+	tests := []struct {
+		a, b  []int
+		shift int
+		want  int
+	}{
+		{[]int{0, 4, 7}, []int{0, 4, 7}, 0, 0},
+		{[]int{0, 4, 7, 11}, []int{0, 4, 7}, 0, 1},
+		{[]int{0, 3, 8}, []int{0, 4, 7}, -4, 0},
+		{[]int{0, 3, 8}, []int{0, 4, 7}, 0, 4},
+		{[]int{0}, []int{0, 7}, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := classDistance(tt.a, tt.b, tt.shift); got != tt.want {
+			t.Errorf("classDistance(%v, %v, %d) = %d, want %d", tt.a, tt.b, tt.shift, got, tt.want)
+		}
+	}
+}
+
+func TestPitchClasses(t *testing.T) {
+	tests := []struct {
+		values []int
+		want   string
+	}{
+		{[]int{0, 0, 4, 4, 7, 7}, "[0 4 7]"},
+		{[]int{0, 12, 16, -5}, "[0 4 7]"},
+		{[]int{-1}, "[11]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(pitchClasses(tt.values)); got != tt.want {
+			t.Errorf("pitchClasses(%v) = %s, want %s", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestSettingsOrnament(t *testing.T) {
+	tests := []struct {
+		settings string
+		want     int
+		ok       bool
+	}{
+		{"ornament:7", 7, true},
+		{"ornament:12", 12, true},
+		{"", 0, false},
+		{"volume:3", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := settingsOrnament(tt.settings)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("settingsOrnament(%q) = %d, %v, want %d, %v", tt.settings, got, ok, tt.want, tt.ok)
+		}
+	}
+}
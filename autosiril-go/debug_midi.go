@@ -20,7 +20,7 @@ func DebugMIDI(filename string) error {
 	}
 
 	fmt.Printf("MIDI File: %s\n", filename)
-	fmt.Printf("Format: %d\n", smfFile.Format)
+	fmt.Printf("Format: %d\n", smfFile.Format())
 	fmt.Printf("Number of tracks: %d\n", len(smfFile.Tracks))
 	fmt.Printf("Time format: %v\n", smfFile.TimeFormat)
 
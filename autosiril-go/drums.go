@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Drum classes used to choose between simultaneous hits on a "d" channel
+const (
+	DrumKick   = "kick"
+	DrumSnare  = "snare"
+	DrumClap   = "clap"
+	DrumTom    = "tom"
+	DrumHat    = "hat"
+	DrumCymbal = "cymbal"
+	DrumPerc   = "perc"
+)
+
+// DefaultDrumPriority is the class order used when "{drumprio=...}" is not given
+var DefaultDrumPriority = []string{DrumKick, DrumSnare, DrumClap, DrumTom, DrumPerc, DrumHat, DrumCymbal}
+
+// drumClass returns the class of a General MIDI percussion note
+func drumClass(note int) string {
+	switch note {
+	case 35, 36:
+		return DrumKick
+	case 37, 38, 40:
+		return DrumSnare
+	case 39:
+		return DrumClap
+	case 41, 43, 45, 47, 48, 50:
+		return DrumTom
+	case 42, 44, 46:
+		return DrumHat
+	case 49, 51, 52, 53, 55, 57, 59:
+		return DrumCymbal
+	}
+	return DrumPerc
+}
+
+// parseDrumPriority parses a "kick.snare.tom.hat" class order. Classes that
+// are not listed rank below the listed ones in their default order.
+func parseDrumPriority(value string) ([]string, error) {
+	var order []string
+	seen := make(map[string]bool)
+	for _, class := range strings.Split(value, ".") {
+		known := false
+		for _, c := range DefaultDrumPriority {
+			if c == class {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown drum class '%s' (expected %s)", class, strings.Join(DefaultDrumPriority, ", "))
+		}
+		if seen[class] {
+			return nil, fmt.Errorf("drum class '%s' listed twice", class)
+		}
+		seen[class] = true
+		order = append(order, class)
+	}
+	for _, c := range DefaultDrumPriority {
+		if !seen[c] {
+			order = append(order, c)
+		}
+	}
+	return order, nil
+}
+
+// drumRank returns the position of a note's class in the priority order
+func drumRank(note int, priority []string) int {
+	class := drumClass(note)
+	for i, c := range priority {
+		if c == class {
+			return i
+		}
+	}
+	return len(priority)
+}
+
+// flattenDrums places drum hits on the timeline like Ruby's flat_cell_drum,
+// but picks between simultaneous hits by drum class instead of note number.
+// Hits are one-shot samples so no release rows are written. With DrumSpill
+// a losing hit moves to the next free row within DrumSpill rows.
+func (pp *PolyphonicProcessor) flattenDrums(timeline []*TimelineNote, spans []*monoSpan, setting *ChannelSettings) {
+	priority := setting.DrumPriority
+	if len(priority) == 0 {
+		priority = DefaultDrumPriority
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		ri, rj := drumRank(spans[i].note.Note, priority), drumRank(spans[j].note.Note, priority)
+		if ri != rj {
+			return ri < rj
+		}
+		return spans[i].note.Note < spans[j].note.Note // Ruby keeps cell.min
+	})
+
+	hitRows := make(map[int]bool)
+	for _, span := range spans {
+		hitRows[span.start] = true
+	}
+
+	dropped, spilled := 0, 0
+	for _, span := range spans {
+		pos := span.start
+		if timeline[pos].Type != "." {
+			pos = -1
+			for off := 1; off <= setting.DrumSpill && span.start+off < len(timeline); off++ {
+				row := span.start + off
+				if timeline[row].Type == "." && !hitRows[row] {
+					pos = row
+					break
+				}
+			}
+			if pos < 0 {
+				dropped++
+				continue
+			}
+			spilled++
+		}
+
+		timeline[pos] = NewTimelineNote(span.note.Note, span.note.Volume, "s")
+		timeline[pos].InstrumentKind = setting.InstrumentType
+		timeline[pos].Channel = span.note.Channel
+		timeline[pos].Settings = span.note.Settings
+		timeline[pos].Sources = []*VirtualNote{span.note}
+	}
+
+	if dropped > 0 || spilled > 0 {
+		pp.config.logf("Track %d drums: %d hits spilled, %d hits dropped\n", setting.MIDIChannel, spilled, dropped)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDrumClass(t *testing.T) {
+	tests := []struct {
+		note int
+		want string
+	}{
+		{35, DrumKick},
+		{36, DrumKick},
+		{38, DrumSnare},
+		{39, DrumClap},
+		{45, DrumTom},
+		{42, DrumHat},
+		{46, DrumHat},
+		{49, DrumCymbal},
+		{56, DrumPerc},
+		{60, DrumPerc},
+	}
+	for _, tt := range tests {
+		if got := drumClass(tt.note); got != tt.want {
+			t.Errorf("drumClass(%d) = %s, want %s", tt.note, got, tt.want)
+		}
+	}
+}
+
+func TestTransposeKeepsDrums(t *testing.T) {
+	config := newTestConfig(t)
+	config.DiatonicTranspose = 1
+	channels, err := parseChannelMapping("1d-2m,3d-3p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []*VirtualNote{
+		{Note: 38, Channel: 1}, // snare stays a snare
+		{Note: 60, Channel: 2},
+		{Note: 60, Channel: 3}, // also played melodically, so transposed
+	}
+	NewKeyProcessor(config).TransposeNotes(notes, 0, channels)
+	for i, want := range []int{38, 62, 62} {
+		if notes[i].Note != want {
+			t.Errorf("note %d of track %d transposed to %d, want %d", i, notes[i].Channel, notes[i].Note, want)
+		}
+	}
+}
+
+// Before drum tracks were kept out of the transposition, the snare below
+// became note 40, written as note 78 on sample 31
+func TestTransposedDrumSamples(t *testing.T) {
+	config := newTestConfig(t)
+	config.DiatonicTranspose = 1
+	channels, err := parseChannelMapping("1d,2m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []*VirtualNote{
+		{Note: 38, Volume: 15, Start: 0, Off: 1, Length: 1, Channel: 1},
+		{Note: 60, Volume: 15, Start: 0, Off: 2, Length: 2, Channel: 2},
+	}
+	NewKeyProcessor(config).TransposeNotes(notes, 0, channels)
+	ay, _, _, err := convertMapping(config, channels, notes, nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drum := ay[0][0]; drum.Note != 60 || drum.Sample != 12 {
+		t.Errorf("snare written as note %d sample %d, want note 60 sample 12 (was note 78 sample 31)", drum.Note, drum.Sample)
+	}
+	if lead := ay[1][0]; lead.Note != 62 {
+		t.Errorf("melody written as note %d, want 62", lead.Note)
+	}
+}
+
+func TestParseDrumPriority(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+		err   bool
+	}{
+		{"hat.kick", []string{DrumHat, DrumKick, DrumSnare, DrumClap, DrumTom, DrumPerc, DrumCymbal}, false},
+		{"kick.snare.clap.tom.perc.hat.cymbal", DefaultDrumPriority, false},
+		{"kick.bass", nil, true},
+		{"kick.kick", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDrumPriority(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFlattenDrums(t *testing.T) {
+	// Kick, snare and hat on row 0, hat on row 2
+	notes := []*VirtualNote{
+		{Note: 42, Volume: 15, Start: 0, Off: 1, Channel: 1},
+		{Note: 38, Volume: 15, Start: 0, Off: 2, Channel: 1},
+		{Note: 36, Volume: 15, Start: 0, Off: 2, Channel: 1},
+		{Note: 42, Volume: 15, Start: 2, Off: 3, Channel: 1},
+	}
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"1d", "0:s36 2:s42"},
+		{"1d{drumprio=hat}", "0:s42 2:s42"},
+		{"1d{spill}", "0:s36 1:s38 2:s42"},
+		{"1d{spill=3}", "0:s36 1:s38 2:s42 3:s42"},
+		{"1d{drumprio=snare.hat,spill=3}", "0:s38 1:s42 2:s42 3:s36"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		timelines, _, err := NewPolyphonicProcessor(newTestConfig(t)).FlattenNotes(notes, 8, channels)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		if got := renderCells(timelines[0]); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
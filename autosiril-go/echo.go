@@ -1,6 +1,67 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultEchoDecay is the volume factor of each tap, in percent (Ruby's 0.7)
+const DefaultEchoDecay = 70
+
+// Echo priority policies selected with "{echoprio=...}"
+const (
+	EchoPrioOriginal = "original" // taps only land on empty rows
+	EchoPrioRelease  = "release"  // taps may also replace release rows (Ruby behaviour, default)
+	EchoPrioSustain  = "sustain"  // taps may also cut notes held for EchoSustain rows
+)
+
+// echoTapStats counts what happened to the echoes of note starts for one tap
+type echoTapStats struct {
+	delay   int
+	placed  int
+	routed  int // handed to the mixer for another AY channel
+	busy    int
+	quiet   int
+	pastEnd int
+}
+
+// reportEcho prints the placed and discarded taps of one virtual channel
+func (ep *EchoProcessor) reportEcho(setting *ChannelSettings, stats []echoTapStats) {
+	parts := make([]string, len(stats))
+	for i, st := range stats {
+		parts[i] = fmt.Sprintf("+%d rows %d placed", st.delay, st.placed)
+		if st.routed > 0 {
+			parts[i] += fmt.Sprintf(", %d routed", st.routed)
+		}
+		parts[i] += fmt.Sprintf(", %d on busy rows", st.busy)
+		if st.quiet > 0 {
+			parts[i] += fmt.Sprintf(", %d below cutoff", st.quiet)
+		}
+		if st.pastEnd > 0 {
+			parts[i] += fmt.Sprintf(", %d past the end", st.pastEnd)
+		}
+	}
+	ep.config.logf("echo track %d (%s): %s\n", setting.MIDIChannel, echoPriority(setting), strings.Join(parts, "; "))
+}
+
+func echoPriority(setting *ChannelSettings) string {
+	switch setting.EchoPriority {
+	case "":
+		return EchoPrioRelease
+	case EchoPrioSustain:
+		return fmt.Sprintf("%s:%d", EchoPrioSustain, setting.EchoSustain)
+	}
+	return setting.EchoPriority
+}
+
+// RoutedEcho holds echo taps of one virtual channel that are played on
+// another AY channel (see the "pingpong" entry option)
+type RoutedEcho struct {
+	Target   int // AY channel index
+	Setting  ChannelSettings
+	Timeline []*TimelineNote
+}
 
 // EchoProcessor handles delay and echo effects
 type EchoProcessor struct {
@@ -11,16 +72,266 @@ func NewEchoProcessor(config *AutosirilConfig) *EchoProcessor {
 	return &EchoProcessor{config: config}
 }
 
-// ApplyEcho applies echo/delay effects to timeline notes
-func (ep *EchoProcessor) ApplyEcho(timelines [][]*TimelineNote, channelSettings [][]ChannelSettings) [][]*TimelineNote {
-	fmt.Println("--- applying delays ---")
-	
-	// For simplified implementation, just return the timelines as-is
-	// In full implementation, this would:
-	// 1. Apply primary delay (perDelay lines, 0.7 volume)
-	// 2. Apply secondary delay (perDelay2 lines, 0.49 volume) 
-	// 3. Handle 'u' modifier (mute echo)
-	// 4. Handle 'w' modifier (double echo)
-	
-	return timelines
-}
\ No newline at end of file
+// ApplyEcho writes delayed, quieter copies of every virtual channel's notes
+// into its own empty and release rows, like Ruby's apply_delays. Echoes are
+// never echoed again and never replace notes or earlier echoes. Taps routed
+// to another AY channel are returned separately for the mixer to place.
+func (ep *EchoProcessor) ApplyEcho(timelines [][]*TimelineNote, channelSettings [][]ChannelSettings) ([][]*TimelineNote, []RoutedEcho) {
+	ep.config.logf("--- applying delays ---\n")
+
+	var routed []RoutedEcho
+	vChanIndex := 0
+	for _, ayChannel := range channelSettings {
+		for _, setting := range ayChannel {
+			if vChanIndex >= len(timelines) {
+				return timelines, routed
+			}
+			ep.config.logf("lchan:%d\n", vChanIndex)
+			if (ep.config.Echo || setting.Echo) && !strings.Contains(setting.Modifiers, "u") {
+				echoed, pingPong := ep.echoTimeline(timelines[vChanIndex], &setting)
+				timelines[vChanIndex] = echoed
+				if pingPong != nil {
+					routed = append(routed, RoutedEcho{Target: setting.PingPong, Setting: setting, Timeline: pingPong})
+				}
+			}
+			vChanIndex++
+		}
+	}
+	return timelines, routed
+}
+
+// echoTimeline returns the timeline with its echoes and, for ping-pong
+// entries, the timeline of the taps played on the other AY channel: the
+// first, third and further odd taps
+func (ep *EchoProcessor) echoTimeline(timeline []*TimelineNote, setting *ChannelSettings) ([]*TimelineNote, []*TimelineNote) {
+	delays := ep.echoDelays(setting)
+	echoed := make([]*TimelineNote, len(timeline))
+	copy(echoed, timeline)
+
+	var pingPong []*TimelineNote
+	if setting.PingPong >= 0 {
+		pingPong = make([]*TimelineNote, len(timeline))
+		for i := range pingPong {
+			pingPong[i] = NewTimelineNote(0, 0, ".")
+		}
+	}
+
+	// heldFor counts the rows since the start of the note sounding on a row
+	heldFor := make([]int, len(timeline))
+	for row, note := range timeline {
+		if row > 0 && (note.Type == "c" || note.Type == "o") {
+			heldFor[row] = heldFor[row-1] + 1
+		}
+	}
+
+	stats := make([]echoTapStats, len(delays))
+	for tap, delay := range delays {
+		stats[tap].delay = delay
+	}
+	for row, note := range timeline {
+		if note.Type == "." {
+			continue
+		}
+		// The furthest tap first, so that it wins over the next note's nearer tap
+		for tap := len(delays) - 1; tap >= 0; tap-- {
+			pos := row + delays[tap]
+			target := echoed
+			routed := pingPong != nil && tap%2 == 0
+			if routed {
+				target = pingPong
+			}
+			st := &stats[tap]
+			volume := echoVolume(note.Volume, tap, setting)
+			switch {
+			case pos >= len(target):
+				if note.Type == "s" {
+					st.pastEnd++
+				}
+			case !echoFits(target[pos], heldFor[pos], setting):
+				if note.Type == "s" {
+					st.busy++
+				}
+			case volume < setting.EchoCutoff || volume < 1:
+				if note.Type == "s" {
+					st.quiet++
+				}
+			default:
+				echo := *note
+				echo.Volume = volume
+				echo.Echo = true
+				echo.Sources = nil
+				target[pos] = &echo
+				if note.Type == "s" && routed {
+					st.routed++
+				} else if note.Type == "s" {
+					st.placed++
+				}
+			}
+		}
+	}
+	ep.reportEcho(setting, stats)
+	return echoed, pingPong
+}
+
+// echoFits reports whether an echo may be written over cell, which belongs
+// to a note held for heldFor rows. Earlier echoes are only replaced on
+// their release rows.
+func echoFits(cell *TimelineNote, heldFor int, setting *ChannelSettings) bool {
+	switch cell.Type {
+	case ".":
+		return true
+	case "r":
+		return setting.EchoPriority != EchoPrioOriginal
+	case "c", "o":
+		return setting.EchoPriority == EchoPrioSustain && !cell.Echo && heldFor >= setting.EchoSustain
+	}
+	return false
+}
+
+// echoDelays returns the tap delays in rows, doubled by the "w" modifier
+func (ep *EchoProcessor) echoDelays(setting *ChannelSettings) []int {
+	delays := setting.EchoDelays
+	if delays == nil {
+		delays = []int{ep.config.PerDelay, ep.config.PerDelay2}
+	}
+	if !strings.Contains(setting.Modifiers, "w") {
+		return delays
+	}
+	doubled := make([]int, len(delays))
+	for i, delay := range delays {
+		doubled[i] = delay * 2
+	}
+	return doubled
+}
+
+// echoVolume returns the volume of a tap. A single decay value is applied
+// once per tap, a list gives every tap's level in percent of the note.
+func echoVolume(volume, tap int, setting *ChannelSettings) int {
+	decay := setting.EchoDecay
+	if decay == nil {
+		decay = []int{DefaultEchoDecay}
+	}
+	if len(decay) > 1 {
+		return int(float64(volume) * float64(decay[tap]) / 100)
+	}
+	level := float64(volume)
+	for i := 0; i <= tap; i++ {
+		level *= float64(decay[0]) / 100
+	}
+	return int(level)
+}
+
+// parseEchoOption applies one of the echo, decay, cutoff, echosample and
+// echoorn options, which all turn echo on for the entry. "echo" without a
+// value keeps the default taps.
+func parseEchoOption(setting *ChannelSettings, name, value string) error {
+	setting.Echo = true
+	switch name {
+	case "echo":
+		if value == "" {
+			return nil // the default taps
+		}
+	case "echosample", "echoorn":
+		id, err := parseOptionID(value)
+		if err != nil {
+			return fmt.Errorf("option '%s': %v", name, err)
+		}
+		if name == "echosample" {
+			if id < 1 || id >= len(Params) {
+				return fmt.Errorf("sample %d out of range 1-%d", id, len(Params)-1)
+			}
+			setting.EchoSample = id
+		} else {
+			if id > 15 {
+				return fmt.Errorf("ornament %d out of range 0-15", id)
+			}
+			setting.EchoOrnament = id
+		}
+		return nil
+	case "cutoff":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 15 {
+			return fmt.Errorf("cutoff expects a volume 1-15, got '%s'", value)
+		}
+		setting.EchoCutoff = n
+		return nil
+	}
+
+	var values []int
+	for _, field := range strings.Split(value, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("option '%s' expects dot-separated numbers, got '%s'", name, value)
+		}
+		values = append(values, n)
+	}
+	for _, n := range values {
+		if name == "echo" && (n < 1 || n > 64) {
+			return fmt.Errorf("echo delay %d out of range 1-64 rows", n)
+		}
+		if name == "decay" && (n < 1 || n > 100) {
+			return fmt.Errorf("decay %d out of range 1-100 percent", n)
+		}
+	}
+	if name == "echo" {
+		setting.EchoDelays = values
+	} else {
+		setting.EchoDecay = values
+	}
+	return nil
+}
+
+// parseEchoPriority parses "original", "release" or "sustain:N"
+func parseEchoPriority(setting *ChannelSettings, value string) error {
+	setting.Echo = true
+	name, rows, hasRows := strings.Cut(value, ":")
+	switch {
+	case (name == EchoPrioOriginal || name == EchoPrioRelease) && !hasRows:
+		setting.EchoPriority = name
+		return nil
+	case name == EchoPrioSustain:
+		n, err := strconv.Atoi(rows)
+		if err != nil || n < 1 {
+			return fmt.Errorf("echoprio=sustain expects the rows a note keeps before echoes may cut it, like sustain:4")
+		}
+		setting.EchoPriority = name
+		setting.EchoSustain = n
+		return nil
+	}
+	return fmt.Errorf("unknown echo priority '%s' (expected original, release or sustain:N)", value)
+}
+
+// parsePingPongOption selects the AY channel, "a" to "c", receiving every
+// other echo tap
+func parsePingPongOption(setting *ChannelSettings, value string) error {
+	setting.Echo = true
+	if strings.Contains(setting.Modifiers, "u") {
+		return fmt.Errorf("option 'pingpong' needs echo, but the entry has the 'u' modifier")
+	}
+	switch strings.ToLower(value) {
+	case "a":
+		setting.PingPong = 0
+	case "b":
+		setting.PingPong = 1
+	case "c":
+		setting.PingPong = 2
+	default:
+		return fmt.Errorf("pingpong expects an AY channel a, b or c, got '%s'", value)
+	}
+	return nil
+}
+
+// parseOptionID parses a sample or ornament id written like in "[SO]": one
+// character of the Params alphabet, or a decimal number
+func parseOptionID(value string) (int, error) {
+	if len(value) == 1 {
+		if id, ok := parseParamChar(value[0]); ok {
+			return id, nil
+		}
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("expected an id like 'F' or a number, got '%s'", value)
+	}
+	return id, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// renderEchoes formats the non-empty cells as "row:type note/volume", with
+// "*" marking echoes
+func renderEchoes(timeline []*TimelineNote) string {
+	var cells []string
+	for row, cell := range timeline {
+		if cell.Type == "." {
+			continue
+		}
+		text := fmt.Sprintf("%d:%s%d/%d", row, cell.Type, cell.Note, cell.Volume)
+		if cell.Echo {
+			text += "*"
+		}
+		cells = append(cells, text)
+	}
+	return strings.Join(cells, " ")
+}
+
+func TestApplyEcho(t *testing.T) {
+	tests := []struct {
+		mapping string
+		flags   []string
+		want    string
+	}{
+		{"1m", nil, "0:s60/15 2:r60/15"},
+		{"1m", []string{"--echo"}, "0:s60/15 2:r60/15 3:s60/10* 5:r60/10* 6:s60/7* 8:r60/7*"},
+		{"1m{echo}", nil, "0:s60/15 2:r60/15 3:s60/10* 5:r60/10* 6:s60/7* 8:r60/7*"},
+		{"1mu", []string{"--echo"}, "0:s60/15 2:r60/15"},
+		{"1mw", []string{"--echo"}, "0:s60/15 2:r60/15 6:s60/10* 8:r60/10*"},
+		{"1m{cutoff=8}", nil, "0:s60/15 2:r60/15 3:s60/10* 5:r60/10*"},
+		{"1m{echo=2.4,decay=80.40}", nil, "0:s60/15 2:s60/12* 4:s60/6* 6:r60/6*"},
+		{"1m{echo=1,decay=50}", nil, "0:s60/15 1:s60/7* 2:r60/15 3:r60/7*"},
+		{"1m{echo=2}", nil, "0:s60/15 2:s60/10* 4:r60/10*"},
+		{"1m{echo=2,echoprio=original}", nil, "0:s60/15 2:r60/15 4:r60/10*"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		timeline := make([]*TimelineNote, 10)
+		for i := range timeline {
+			timeline[i] = NewTimelineNote(0, 0, ".")
+		}
+		timeline[0] = NewTimelineNote(60, 15, "s")
+		timeline[2] = NewTimelineNote(60, 15, "r")
+
+		echoed, _ := NewEchoProcessor(newTestConfig(t, tt.flags...)).ApplyEcho([][]*TimelineNote{timeline}, channels)
+		if got := renderEchoes(echoed[0]); got != tt.want {
+			t.Errorf("%q %v: got %q, want %q", tt.mapping, tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestEchoFits(t *testing.T) {
+	echo := NewTimelineNote(60, 10, "c")
+	echo.Echo = true
+	tests := []struct {
+		cell     *TimelineNote
+		heldFor  int
+		priority string
+		want     bool
+	}{
+		{NewTimelineNote(0, 0, "."), 0, EchoPrioOriginal, true},
+		{NewTimelineNote(60, 15, "r"), 0, EchoPrioOriginal, false},
+		{NewTimelineNote(60, 15, "r"), 0, "", true},
+		{NewTimelineNote(60, 15, "r"), 0, EchoPrioRelease, true},
+		{NewTimelineNote(60, 15, "c"), 5, EchoPrioRelease, false},
+		{NewTimelineNote(60, 15, "c"), 3, EchoPrioSustain, false},
+		{NewTimelineNote(60, 15, "c"), 4, EchoPrioSustain, true},
+		{NewTimelineNote(60, 15, "o"), 4, EchoPrioSustain, true},
+		{echo, 4, EchoPrioSustain, false},
+		{NewTimelineNote(60, 15, "s"), 9, EchoPrioSustain, false},
+	}
+	for i, tt := range tests {
+		setting := &ChannelSettings{EchoPriority: tt.priority, EchoSustain: 4}
+		if got := echoFits(tt.cell, tt.heldFor, setting); got != tt.want {
+			t.Errorf("case %d: echoFits(%s, %d, %q) = %v, want %v", i, tt.cell.Type, tt.heldFor, tt.priority, got, tt.want)
+		}
+	}
+}
+
+func TestEchoVolume(t *testing.T) {
+	tests := []struct {
+		decay  []int
+		tap    int
+		volume int
+	}{
+		{nil, 0, 10},
+		{nil, 1, 7},
+		{[]int{50}, 1, 3},
+		{[]int{80, 40}, 0, 12},
+		{[]int{80, 40}, 1, 6},
+	}
+	for _, tt := range tests {
+		setting := &ChannelSettings{EchoDecay: tt.decay}
+		if got := echoVolume(15, tt.tap, setting); got != tt.volume {
+			t.Errorf("decay %v tap %d: got %d, want %d", tt.decay, tt.tap, got, tt.volume)
+		}
+	}
+}
+
+func TestMixEchoSettings(t *testing.T) {
+	tests := []struct {
+		mapping  string
+		sample   int
+		ornament int
+	}{
+		{"1m[3]{echo}", 3, 0},
+		{"1m[3]{echosample=9}", 9, 0},
+		{"1m[3]{echosample=12,echoorn=4}", 12, 4},
+	}
+	for _, tt := range tests {
+		notes := []*VirtualNote{{Note: 60, Volume: 15, Start: 0, Off: 2, Length: 2, Channel: 1}}
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		config := newTestConfig(t)
+		timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 8, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timelines, routed := NewEchoProcessor(config).ApplyEcho(timelines, channels)
+		echo := NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, routed)[0][3]
+		if echo.Sample != tt.sample || echo.Ornament != tt.ornament {
+			t.Errorf("%q: echo sample %d ornament %d, want %d %d", tt.mapping, echo.Sample, echo.Ornament, tt.sample, tt.ornament)
+		}
+	}
+}
+
+func TestPingPongEcho(t *testing.T) {
+	lead := &VirtualNote{Note: 60, Volume: 15, Start: 0, Off: 3, Length: 3, Channel: 1}
+	tests := []struct {
+		name  string
+		notes []*VirtualNote
+		a, b  string
+	}{
+		{"idle target", []*VirtualNote{lead}, "0:s60 2:r60 6:s60 8:r60", "3:s60 5:r60"},
+		{"busy target", []*VirtualNote{lead, {Note: 48, Volume: 15, Start: 3, Off: 5, Length: 2, Channel: 2}}, "0:s60 2:r60 6:s60 8:r60", "3:s48 4:r48"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping("1m{pingpong=b},2mu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := newTestConfig(t)
+		timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(tt.notes, 11, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timelines, routed := NewEchoProcessor(config).ApplyEcho(timelines, channels)
+		ay := NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, routed)
+		for i, want := range []string{tt.a, tt.b} {
+			var cells []string
+			for row, cell := range ay[i] {
+				if cell.Type != "." {
+					cells = append(cells, fmt.Sprintf("%d:%s%d", row, cell.Type, cell.Note))
+				}
+			}
+			if got := strings.Join(cells, " "); got != want {
+				t.Errorf("%s: channel %c %q, want %q", tt.name, 'A'+i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+// EnvelopeFallbackSample is the plain tone sample of envelope notes that
+// lose the hardware envelope, like echoes on envelope channels
+const EnvelopeFallbackSample = 2
+
+// EnvelopeOff is the envelope column value switching the hardware envelope
+// off on a channel
+const EnvelopeOff = 15
+
+// envelopeChannel is the envelope state of one AY channel, like pforms in
+// Ruby: whether its sounding note plays the hardware envelope
+type envelopeChannel struct {
+	enabled  bool // the last form written on the channel is an envelope form
+	priority int  // "{envprio}" of the sounding envelope note
+	cutOff   bool // the envelope must be switched off on the next free row
+}
+
+// envelopeStats counts the arbitration results
+type envelopeStats struct {
+	notes, periods, forms, fallbacks, cutOffs int
+}
+
+// ArbitrateEnvelopes shares the single AY envelope generator between the
+// "e" notes of all channels. A note starting on a row takes the envelope
+// unless a sounding note of higher "{envprio}" holds it; among notes starting
+// together the higher priority, then the louder note wins. Notes that lose
+// play their tone on the fallback sample, and held envelope notes losing
+// their envelope are switched to tone on their next free row. The envelope
+// period and form are only written when they change.
+func (cm *ChannelMixer) ArbitrateEnvelopes(channels [][]*VortexNote) {
+	var stats envelopeStats
+	states := make([]envelopeChannel, len(channels))
+	var period *VortexNote // note whose envelope period is loaded
+	form := 0              // envelope form register, 0 = not written yet
+
+	rows := 0
+	if len(channels) > 0 {
+		rows = len(channels[0])
+	}
+	for pos := 0; pos < rows; pos++ {
+		var starts []int
+		for ch, channel := range channels {
+			switch cell := channel[pos]; {
+			case cell.Type == "s" && cell.EnvelopeActive():
+				starts = append(starts, ch)
+			case cell.Type == "s" || cell.Type == "r":
+				states[ch] = envelopeChannel{}
+			}
+		}
+
+		// The newest envelope note wins unless a held note has a higher priority
+		winner := -1
+		for _, ch := range starts {
+			if winner < 0 || envelopeBeats(channels[ch][pos], channels[winner][pos]) {
+				winner = ch
+			}
+		}
+		if winner >= 0 && !playsEnvelope(channels[winner][pos], period, form) {
+			note := channels[winner][pos]
+			if heldEnvelopePriority(states, starts) > note.EnvPriority {
+				winner = -1
+			} else {
+				if period == nil || !samePeriod(note, period) {
+					note.EnvPeriod = true
+					stats.periods++
+				}
+				period = note
+				for ch := range states {
+					if states[ch].enabled && !containsInt(starts, ch) {
+						states[ch].cutOff = true
+					}
+				}
+			}
+		}
+		target := form
+		if winner >= 0 {
+			target = channels[winner][pos].Envelope
+		}
+
+		// Notes playing the winning envelope share it, the others play tone
+		formWritten := false
+		for _, ch := range starts {
+			note := channels[ch][pos]
+			stats.notes++
+			if !playsEnvelope(note, period, target) {
+				note.Envelope = EnvelopeOff
+				note.Sample = EnvelopeFallbackSample
+				states[ch] = envelopeChannel{}
+				stats.fallbacks++
+				continue
+			}
+			if states[ch].enabled && !states[ch].cutOff && form == target {
+				note.Envelope = 0 // the channel keeps playing the envelope
+			} else {
+				formWritten = true
+			}
+			states[ch] = envelopeChannel{enabled: true, priority: note.EnvPriority}
+		}
+		if formWritten {
+			form = target
+			stats.forms++
+		}
+
+		for ch, state := range states {
+			if !state.cutOff {
+				continue
+			}
+			if cell := channels[ch][pos]; cell.Type == "." || cell.Type == "c" {
+				off := NewVortexNote(NewTimelineNote(0, 0, "."))
+				off.Envelope = EnvelopeOff
+				off.Sources = cell.Sources
+				channels[ch][pos] = off
+				states[ch] = envelopeChannel{}
+				stats.cutOffs++
+			}
+		}
+	}
+
+	if stats.notes > 0 {
+		cm.config.logf("envelope: %d notes, %d period changes, %d form writes, %d fell back to tone, %d held notes switched to tone\n",
+			stats.notes, stats.periods, stats.forms, stats.fallbacks, stats.cutOffs)
+	}
+}
+
+// envelopeBeats reports whether note wins the envelope over other, both
+// starting on the same row
+func envelopeBeats(note, other *VortexNote) bool {
+	if note.EnvPriority != other.EnvPriority {
+		return note.EnvPriority > other.EnvPriority
+	}
+	return note.Volume > other.Volume
+}
+
+// playsEnvelope reports whether note plays the loaded envelope period and form
+func playsEnvelope(note, period *VortexNote, form int) bool {
+	return period != nil && samePeriod(note, period) && note.Envelope == form
+}
+
+// heldEnvelopePriority returns the highest priority of the envelope notes
+// sounding from earlier rows, or -1
+func heldEnvelopePriority(states []envelopeChannel, starts []int) int {
+	priority := -1
+	for ch, state := range states {
+		if state.enabled && !state.cutOff && !containsInt(starts, ch) && state.priority > priority {
+			priority = state.priority
+		}
+	}
+	return priority
+}
+
+// samePeriod reports whether two notes play the same envelope period
+func samePeriod(a, b *VortexNote) bool {
+	return a.EnvelopePitch == b.EnvelopePitch && a.EnvelopeOctave == b.EnvelopeOctave
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
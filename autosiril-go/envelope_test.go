@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// envelopeChannels builds three AY channels of rows empty rows
+func envelopeChannels(rows int) [][]*VortexNote {
+	channels := make([][]*VortexNote, 3)
+	for ch := range channels {
+		channels[ch] = make([]*VortexNote, rows)
+		for pos := range channels[ch] {
+			channels[ch][pos] = NewVortexNote(NewTimelineNote(0, 0, "."))
+		}
+	}
+	return channels
+}
+
+// envelopeNote returns an "e" note start with an envelope priority
+func envelopeNote(note, priority int) *VortexNote {
+	timelineNote := NewTimelineNote(note, 15, "s")
+	timelineNote.InstrumentKind = "e"
+	vn := NewVortexNote(timelineNote)
+	vn.Sample = 4
+	vn.EnvPriority = priority
+	return vn
+}
+
+// renderEnvelopes renders a channel as row:type/form/sample, with "P" where
+// the row writes the envelope period
+func renderEnvelopes(channel []*VortexNote) string {
+	var parts []string
+	for pos, cell := range channel {
+		if cell.Type == "." && cell.Envelope == 0 {
+			continue
+		}
+		part := fmt.Sprintf("%d:%s/%d/%d", pos, cell.Type, cell.Envelope, cell.Sample)
+		if cell.EnvPeriod {
+			part += "P"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestArbitrateEnvelopes(t *testing.T) {
+	type start struct{ ch, pos, note, priority int }
+	tests := []struct {
+		name   string
+		starts []start
+		a, b   string
+	}{
+		{"same period kept", []start{{0, 0, 36, 0}, {0, 2, 36, 0}}, "0:s/10/4P 2:s/0/4", ""},
+		{"higher priority wins together", []start{{0, 0, 36, 0}, {1, 0, 40, 1}}, "0:s/15/2", "0:s/10/4P"},
+		{"held priority keeps the envelope", []start{{0, 0, 36, 1}, {1, 2, 40, 0}}, "0:s/10/4P", "2:s/15/2"},
+		{"newer note takes the envelope", []start{{0, 0, 36, 0}, {1, 2, 40, 0}}, "0:s/10/4P 2:./15/2", "2:s/10/4P"},
+	}
+	for _, tt := range tests {
+		channels := envelopeChannels(5)
+		for _, s := range tt.starts {
+			channels[s.ch][s.pos] = envelopeNote(s.note, s.priority)
+		}
+		NewChannelMixer(newTestConfig(t), nil).ArbitrateEnvelopes(channels)
+		if got := renderEnvelopes(channels[0]); got != tt.a {
+			t.Errorf("%s: channel A %q, want %q", tt.name, got, tt.a)
+		}
+		if got := renderEnvelopes(channels[1]); got != tt.b {
+			t.Errorf("%s: channel B %q, want %q", tt.name, got, tt.b)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "sort"
+
+// Policies for notes shorter than one row, selected with --grace
+const (
+	GraceDrop   = "drop"   // discard the note (Ruby behaviour, default)
+	GraceExtend = "extend" // stretch the note to one row
+	GraceMerge  = "merge"  // turn it into the first ornament step of the next note
+)
+
+// GraceStats counts notes that round to zero rows
+type GraceStats struct {
+	Total    int
+	Dropped  int
+	Extended int
+	Merged   int
+}
+
+// reportGrace prints how many sub-row notes were found and what happened to them
+func (mp *MidiProcessor) reportGrace(gs *GraceStats) {
+	if gs.Total == 0 {
+		return
+	}
+	mp.config.logf("grace notes: %d shorter than a row (policy %s: %d dropped, %d extended, %d merged)\n",
+		gs.Total, mp.config.GracePolicy, gs.Dropped, gs.Extended, gs.Merged)
+	if gs.Dropped > 0 {
+		mp.config.logf("  consider a finer PER_BEAT or --grace=extend|merge to keep them\n")
+	}
+}
+
+// applyGracePolicy handles the zero-length notes of one track and returns
+// the notes that stay on the track
+func (mp *MidiProcessor) applyGracePolicy(notes []*VirtualNote, stats *GraceStats) []*VirtualNote {
+	var kept, graces []*VirtualNote
+	for _, note := range notes {
+		if note.Length > 0 {
+			kept = append(kept, note)
+		} else {
+			graces = append(graces, note)
+		}
+	}
+	stats.Total += len(graces)
+
+	// Merge in time order so that flams with several graces keep their order
+	sort.SliceStable(graces, func(i, j int) bool {
+		return graces[i].Start < graces[j].Start
+	})
+
+	for _, grace := range graces {
+		switch mp.config.GracePolicy {
+		case GraceExtend:
+			mp.extendGrace(grace)
+			kept = append(kept, grace)
+			stats.Extended++
+		case GraceMerge:
+			if target := graceTarget(grace, kept); target != nil {
+				target.GraceNotes = append(target.GraceNotes, grace.Note)
+				target.Merged = append(target.Merged, grace)
+				stats.Merged++
+			} else {
+				// Nothing to merge into, keep the note audible instead
+				mp.extendGrace(grace)
+				kept = append(kept, grace)
+				stats.Extended++
+			}
+		default:
+			mp.dropped = append(mp.dropped, grace)
+			stats.Dropped++
+		}
+	}
+	return kept
+}
+
+func (mp *MidiProcessor) extendGrace(grace *VirtualNote) {
+	grace.Off = grace.Start + 1
+	grace.Length = 1
+}
+
+// graceTarget finds the note a grace leads into: the earliest note starting
+// on the grace's row or the row after it
+func graceTarget(grace *VirtualNote, notes []*VirtualNote) *VirtualNote {
+	var target *VirtualNote
+	for _, note := range notes {
+		if note.Start < grace.Start || note.Start > grace.Start+1 {
+			continue
+		}
+		if target == nil || note.Start < target.Start || (note.Start == target.Start && note.Note > target.Note) {
+			target = note
+		}
+	}
+	return target
+}
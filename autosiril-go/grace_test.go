@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApplyGracePolicy(t *testing.T) {
+	// Two graces leading into a note one row later, one grace with nothing after it
+	track := func() []*VirtualNote {
+		return []*VirtualNote{
+			{Note: 62, Start: 4, Off: 4, Length: 0},
+			{Note: 60, Start: 4, Off: 4, Length: 0},
+			{Note: 64, Start: 5, Off: 7, Length: 2},
+			{Note: 70, Start: 10, Off: 10, Length: 0},
+		}
+	}
+	tests := []struct {
+		policy string
+		want   string
+		stats  GraceStats
+	}{
+		{GraceDrop, "64@5-7", GraceStats{Total: 3, Dropped: 3}},
+		{GraceExtend, "64@5-7 62@4-5 60@4-5 70@10-11", GraceStats{Total: 3, Extended: 3}},
+		{GraceMerge, "64@5-7[62 60] 70@10-11", GraceStats{Total: 3, Extended: 1, Merged: 2}},
+	}
+	for _, tt := range tests {
+		mp := NewMidiProcessor(newTestConfig(t, "--grace="+tt.policy))
+		stats := &GraceStats{}
+		var got []string
+		for _, note := range mp.applyGracePolicy(track(), stats) {
+			text := fmt.Sprintf("%d@%d-%d", note.Note, note.Start, note.Off)
+			if len(note.GraceNotes) > 0 {
+				text += fmt.Sprint(note.GraceNotes)
+			}
+			got = append(got, text)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.policy, strings.Join(got, " "), tt.want)
+		}
+		if *stats != tt.stats {
+			t.Errorf("%s: stats %+v, want %+v", tt.policy, *stats, tt.stats)
+		}
+	}
+}
+
+func TestOrnamentKey(t *testing.T) {
+	tests := []struct {
+		values []int
+		loop   int
+		key    string
+	}{
+		{[]int{0, 4, 7}, 0, "L0,4,7"},
+		{[]int{2, 0}, 1, "2,L0"},
+		{[]int{-1, -1, 3, 0}, 3, "-1,-1,3,L0"},
+	}
+	for _, tt := range tests {
+		if got := ornamentKey(tt.values, tt.loop); got != tt.key {
+			t.Errorf("ornamentKey(%v, %d) = %q, want %q", tt.values, tt.loop, got, tt.key)
+		}
+		values, loop := parseOrnamentKey(tt.key)
+		if fmt.Sprint(values) != fmt.Sprint(tt.values) || loop != tt.loop {
+			t.Errorf("parseOrnamentKey(%q) = %v, %d, want %v, %d", tt.key, values, loop, tt.values, tt.loop)
+		}
+	}
+}
+
+func TestGraceOrnament(t *testing.T) {
+	og := NewOrnamentGenerator(newTestConfig(t))
+	first := og.GraceOrnament([]int{-2})
+	if again := og.GraceOrnament([]int{-2}); again != first {
+		t.Errorf("same grace offsets got ornaments %d and %d", first, again)
+	}
+	if other := og.GraceOrnament([]int{-2, -4}); other == first {
+		t.Errorf("different grace offsets share ornament %d", other)
+	}
+}
@@ -47,11 +47,13 @@ func (kp *KeyProcessor) DetectKey(notes []*VirtualNote) int {
 	return bestKey
 }
 
-// TransposeNotes applies diatonic transposition to notes
-func (kp *KeyProcessor) TransposeNotes(notes []*VirtualNote, detectedKey int) {
+// TransposeNotes applies diatonic transposition to notes. Tracks mapped only
+// to drum entries keep their notes, which select the drum sounds.
+func (kp *KeyProcessor) TransposeNotes(notes []*VirtualNote, detectedKey int, channelSettings [][]ChannelSettings) {
 	if kp.config.DiatonicTranspose == 0 {
 		return
 	}
+	drums := drumTracks(channelSettings)
 	
 	steps := kp.config.DiatonicTranspose
 	var transposePattern []int
@@ -63,17 +65,42 @@ func (kp *KeyProcessor) TransposeNotes(notes []*VirtualNote, detectedKey int) {
 		steps = -steps
 	}
 	
-	for _, note := range notes {
-		originalNote := note.Note
-		currentNote := note.Note
-		
+	transpose := func(currentNote int) int {
 		for i := 0; i < steps; i++ {
 			pitch := currentNote % 12
 			adjustedPitch := (pitch - detectedKey + 12) % 12
 			currentNote += transposePattern[adjustedPitch]
 		}
-		
-		note.Note = currentNote
-		fmt.Printf("Transposed note %d -> %d\n", originalNote, currentNote)
+		return currentNote
+	}
+	
+	for _, note := range notes {
+		if drums[note.Channel] {
+			continue
+		}
+		originalNote := note.Note
+		note.Note = transpose(note.Note)
+		for i, grace := range note.GraceNotes {
+			note.GraceNotes[i] = transpose(grace)
+		}
+		fmt.Printf("Transposed note %d -> %d\n", originalNote, note.Note)
+	}
+}
+
+// drumTracks returns the MIDI tracks mapped only to "d" entries
+func drumTracks(channelSettings [][]ChannelSettings) map[int]bool {
+	drums, melodic := make(map[int]bool), make(map[int]bool)
+	for _, channel := range channelSettings {
+		for _, setting := range channel {
+			if setting.InstrumentType == "d" {
+				drums[setting.MIDIChannel] = true
+			} else {
+				melodic[setting.MIDIChannel] = true
+			}
+		}
 	}
-}
\ No newline at end of file
+	for track := range melodic {
+		delete(drums, track)
+	}
+	return drums
+}
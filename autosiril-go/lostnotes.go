@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Stages at which a source note can get lost
+const (
+	LostGrace   = "grace"   // shorter than a row and dropped by the grace policy
+	LostFlatten = "flatten" // lost to another note of its track while flattening
+	LostChord   = "chord"   // chord tone beyond MAX_OFFSET left out of the ornament
+	LostMix     = "mix"     // overwritten while mixing into the AY channels
+)
+
+// LostNote is a source note that is missing from the final AY channels
+type LostNote struct {
+	Track    int         `json:"track"`
+	Note     string      `json:"note"`
+	Tick     int         `json:"tick"`
+	Row      int         `json:"row"`
+	Rows     int         `json:"rows"`     // length in rows
+	Position string      `json:"position"` // bar:beat, 1-based, in 4/4
+	Stage    string      `json:"stage"`
+	Reason   string      `json:"reason"`
+	Winner   *LostWinner `json:"winner,omitempty"`
+}
+
+// LostWinner is the note sounding where a lost note should have been
+type LostWinner struct {
+	Track int    `json:"track"`
+	Note  string `json:"note"`
+	Echo  bool   `json:"echo,omitempty"`
+}
+
+// LostNoteReport finds the source notes of the mapped tracks that do not
+// make it into the final channels, by following the Sources of timeline and
+// channel cells through flattening and mixing
+type LostNoteReport struct {
+	config *AutosirilConfig
+	total  int
+	graces []*VirtualNote
+	tracks map[int]bool
+	kept   map[*VirtualNote]int // notes kept by flattening, with their AY channel
+	Lost   []LostNote
+}
+
+func NewLostNoteReport(config *AutosirilConfig) *LostNoteReport {
+	return &LostNoteReport{
+		config: config,
+		tracks: make(map[int]bool),
+		kept:   make(map[*VirtualNote]int),
+	}
+}
+
+// AddGraceNotes records the notes the grace policy dropped
+func (lr *LostNoteReport) AddGraceNotes(notes []*VirtualNote) {
+	lr.graces = append(lr.graces, notes...)
+}
+
+// CheckFlattened records the notes of mapped tracks that no flattened
+// timeline plays. Notes of pooled entries skip flattening and are only
+// checked after mixing.
+func (lr *LostNoteReport) CheckFlattened(virtualNotes []*VirtualNote, timelines [][]*TimelineNote, channelSettings [][]ChannelSettings) {
+	vChanIndex := 0
+	trackTimeline := make(map[int][]*TimelineNote)
+	trackSetting := make(map[int]ChannelSettings)
+	pooled := make(map[int]int)
+	for ayIdx, ayChannel := range channelSettings {
+		for _, setting := range ayChannel {
+			track := setting.MIDIChannel
+			lr.tracks[track] = true
+			if setting.Pool != "" {
+				pooled[track] = ayIdx
+			} else if vChanIndex < len(timelines) {
+				if _, exists := trackTimeline[track]; !exists {
+					trackTimeline[track] = timelines[vChanIndex]
+					trackSetting[track] = setting
+				}
+				for _, cell := range timelines[vChanIndex] {
+					for _, source := range cell.Sources {
+						lr.keep(source, ayIdx)
+					}
+				}
+			}
+			vChanIndex++
+		}
+	}
+
+	for _, grace := range lr.graces {
+		if lr.tracks[grace.Channel] {
+			lr.total++
+			lr.add(grace, LostGrace, "shorter than a row", nil)
+		}
+	}
+
+	for _, vNote := range virtualNotes {
+		if !lr.tracks[vNote.Channel] {
+			continue
+		}
+		lr.total += 1 + len(vNote.Merged) // merged graces count as notes of their own
+		if ayIdx, ok := pooled[vNote.Channel]; ok {
+			lr.keep(vNote, ayIdx)
+			continue
+		}
+		if _, ok := lr.kept[vNote]; ok {
+			continue
+		}
+
+		timeline := trackTimeline[vNote.Channel]
+		row := vNote.Start + lr.config.SkipLines
+		stage, reason := LostFlatten, flattenLossReason(trackSetting[vNote.Channel])
+		var winner *TimelineNote
+		if row < len(timeline) {
+			winner = soundingTimelineNote(timeline, row)
+			if cell := timeline[row]; cell.Type == "s" && containsNote(cell.ChordNotes, vNote.Note) {
+				stage, reason = LostChord, fmt.Sprintf("chord tone beyond MAX_OFFSET %d", lr.config.MaxOffset)
+			}
+		}
+		var w *LostWinner
+		if winner != nil {
+			w = &LostWinner{Track: winner.Channel, Note: winner.String(), Echo: winner.Echo}
+		}
+		lr.add(vNote, stage, reason, w)
+		for _, merged := range vNote.Merged {
+			lr.add(merged, stage, reason, w)
+		}
+	}
+}
+
+// CheckMixed records the notes kept by flattening that the final channels
+// do not play
+func (lr *LostNoteReport) CheckMixed(channels [][]*VortexNote) {
+	played := make(map[*VirtualNote]bool)
+	for _, channel := range channels {
+		for _, cell := range channel {
+			for _, source := range cell.Sources {
+				markPlayed(source, played)
+			}
+		}
+	}
+
+	var missing []*VirtualNote
+	for vNote := range lr.kept {
+		if !played[vNote] {
+			missing = append(missing, vNote)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Tick != missing[j].Tick {
+			return missing[i].Tick < missing[j].Tick
+		}
+		if missing[i].Channel != missing[j].Channel {
+			return missing[i].Channel < missing[j].Channel
+		}
+		return missing[i].Note < missing[j].Note
+	})
+
+	for _, vNote := range missing {
+		ayIdx := lr.kept[vNote]
+		row := vNote.Start + lr.config.SkipLines
+		var winner *LostWinner
+		if ayIdx < len(channels) && row < len(channels[ayIdx]) {
+			if cell := soundingVortexNote(channels[ayIdx], row); cell != nil {
+				winner = &LostWinner{Track: cell.Channel, Note: cell.String(), Echo: len(cell.Sources) == 0}
+			}
+		}
+		lr.add(vNote, LostMix, fmt.Sprintf("not played on channel %c", 'A'+ayIdx), winner)
+	}
+	lr.sortLost()
+}
+
+// keep marks a note and the notes merged into it as kept on an AY channel.
+// Only the first entry of a note is remembered.
+func (lr *LostNoteReport) keep(vNote *VirtualNote, ayIdx int) {
+	if _, exists := lr.kept[vNote]; !exists {
+		lr.kept[vNote] = ayIdx
+	}
+	for _, merged := range vNote.Merged {
+		lr.keep(merged, ayIdx) // played and lost together with vNote
+	}
+}
+
+func (lr *LostNoteReport) add(vNote *VirtualNote, stage, reason string, winner *LostWinner) {
+	beat := vNote.Start / lr.config.PerBeat
+	lr.Lost = append(lr.Lost, LostNote{
+		Track:    vNote.Channel,
+		Note:     vNote.String(),
+		Tick:     vNote.Tick,
+		Row:      vNote.Start + lr.config.SkipLines,
+		Rows:     vNote.Length,
+		Position: fmt.Sprintf("%d:%d", beat/4+1, beat%4+1),
+		Stage:    stage,
+		Reason:   reason,
+		Winner:   winner,
+	})
+}
+
+func (lr *LostNoteReport) sortLost() {
+	sort.SliceStable(lr.Lost, func(i, j int) bool {
+		if lr.Lost[i].Tick != lr.Lost[j].Tick {
+			return lr.Lost[i].Tick < lr.Lost[j].Tick
+		}
+		return lr.Lost[i].Track < lr.Lost[j].Track
+	})
+}
+
+// Report prints the lost notes per track and stage, with the first loss
+func (lr *LostNoteReport) Report() {
+	lr.config.logf("lost notes: %d of %d%s\n", len(lr.Lost), lr.total, stageCounts(lr.Lost))
+	byTrack := make(map[int][]LostNote)
+	var tracks []int
+	for _, lost := range lr.Lost {
+		if _, exists := byTrack[lost.Track]; !exists {
+			tracks = append(tracks, lost.Track)
+		}
+		byTrack[lost.Track] = append(byTrack[lost.Track], lost)
+	}
+	sort.Ints(tracks)
+	for _, track := range tracks {
+		notes := byTrack[track]
+		lr.config.logf("  track %d: %d%s, first %s\n", track, len(notes), stageCounts(notes), notes[0].describe())
+	}
+}
+
+// Save writes every lost note, as JSON when the file name ends in .json and
+// as one line per note otherwise
+func (lr *LostNoteReport) Save(filename string) error {
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		lost := lr.Lost
+		if lost == nil {
+			lost = []LostNote{}
+		}
+		data, err := json.MarshalIndent(struct {
+			Total int        `json:"total"`
+			Lost  []LostNote `json:"lost"`
+		}{lr.total, lost}, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, append(data, '\n'), 0644)
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "lost notes: %d of %d%s\n", len(lr.Lost), lr.total, stageCounts(lr.Lost))
+	for _, lost := range lr.Lost {
+		fmt.Fprintf(&output, "track %d tick %d row %d: %s\n", lost.Track, lost.Tick, lost.Row, lost.describe())
+	}
+	return os.WriteFile(filename, []byte(output.String()), 0644)
+}
+
+// describe returns "D-5 at 4:1 (mix: not played on channel A, lost to C-5 of track 4)"
+func (ln LostNote) describe() string {
+	text := fmt.Sprintf("%s at %s (%s: %s", ln.Note, ln.Position, ln.Stage, ln.Reason)
+	if ln.Winner != nil {
+		source := fmt.Sprintf("of track %d", ln.Winner.Track)
+		if ln.Winner.Echo {
+			source = fmt.Sprintf("echo of track %d", ln.Winner.Track)
+		}
+		text += fmt.Sprintf(", lost to %s %s", ln.Winner.Note, source)
+	}
+	return text + ")"
+}
+
+// stageCounts returns " (grace 2, mix 5)" for a list of lost notes
+func stageCounts(notes []LostNote) string {
+	counts := make(map[string]int)
+	for _, lost := range notes {
+		counts[lost.Stage]++
+	}
+	var parts []string
+	for _, stage := range []string{LostGrace, LostFlatten, LostChord, LostMix} {
+		if counts[stage] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", stage, counts[stage]))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func flattenLossReason(setting ChannelSettings) string {
+	switch setting.InstrumentType {
+	case "d":
+		return "drum hit lost to a higher-priority drum"
+	case "p":
+		return "starts on a row held or released by another note"
+	}
+	priority := setting.Priority
+	if priority == "" {
+		priority = PriorityHigh
+	}
+	return fmt.Sprintf("monophonic priority %s", priority)
+}
+
+func markPlayed(vNote *VirtualNote, played map[*VirtualNote]bool) {
+	played[vNote] = true
+	for _, merged := range vNote.Merged {
+		markPlayed(merged, played)
+	}
+}
+
+func containsNote(notes []int, note int) bool {
+	for _, n := range notes {
+		if n == note {
+			return true
+		}
+	}
+	return false
+}
+
+// soundingTimelineNote returns the note start sounding on row pos, or nil
+func soundingTimelineNote(timeline []*TimelineNote, pos int) *TimelineNote {
+	for ; pos >= 0; pos-- {
+		switch timeline[pos].Type {
+		case "s":
+			return timeline[pos]
+		case "r":
+			return nil
+		}
+	}
+	return nil
+}
+
+// soundingVortexNote returns the note start sounding on row pos of an AY
+// channel, or nil
+func soundingVortexNote(channel []*VortexNote, pos int) *VortexNote {
+	for ; pos >= 0; pos-- {
+		switch channel[pos].Type {
+		case "s":
+			return channel[pos]
+		case "r":
+			return nil
+		}
+	}
+	return nil
+}
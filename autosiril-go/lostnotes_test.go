@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reportLost flattens and mixes notes with a mapping and returns the
+// lost-note report
+func reportLost(t *testing.T, mapping string, notes []*VirtualNote) *LostNoteReport {
+	t.Helper()
+	channels, err := parseChannelMapping(mapping)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	config := newTestConfig(t)
+	timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 16, channels)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	report := NewLostNoteReport(config)
+	report.CheckFlattened(notes, timelines, channels)
+	report.CheckMixed(NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, nil))
+	return report
+}
+
+func TestLostNoteReport(t *testing.T) {
+	note := func(track, key, start, off int) *VirtualNote {
+		return &VirtualNote{Note: key, Volume: 15, Start: start, Off: off, Length: off - start, Channel: track, Tick: start * 24}
+	}
+	tests := []struct {
+		name    string
+		mapping string
+		notes   []*VirtualNote
+		want    []string
+	}{
+		{"nothing lost", "1m", []*VirtualNote{note(1, 60, 0, 4)}, nil},
+		{"monophonic priority", "1mu", []*VirtualNote{note(1, 60, 0, 4), note(1, 64, 0, 4)}, []string{"C-4 at 1:1 (flatten: monophonic priority high, lost to E-4 of track 1)"}},
+		{"chord outlier", "1pu", []*VirtualNote{note(1, 60, 4, 8), note(1, 64, 4, 8), note(1, 90, 4, 8)}, []string{"F#6 at 1:2 (chord: chord tone beyond MAX_OFFSET 12, lost to C-4 of track 1)"}},
+		{"mix", "1mu-2mu", []*VirtualNote{note(1, 60, 0, 4), note(2, 64, 0, 4)}, []string{"E-4 at 1:1 (mix: not played on channel A, lost to C-4 of track 1)"}},
+		{"unmapped track", "1mu", []*VirtualNote{note(1, 60, 0, 4), note(2, 64, 0, 4)}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, lost := range reportLost(t, tt.mapping, tt.notes).Lost {
+			got = append(got, lost.describe())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLostNoteReportSave(t *testing.T) {
+	report := reportLost(t, "1mu", []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 1},
+		{Note: 64, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 1},
+	})
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "lost.json")
+	if err := report.Save(jsonFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Total int        `json:"total"`
+		Lost  []LostNote `json:"lost"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Total != 2 || len(saved.Lost) != 1 || saved.Lost[0].Stage != LostFlatten || saved.Lost[0].Winner == nil {
+		t.Errorf("JSON report = %s", data)
+	}
+
+	textFile := filepath.Join(dir, "lost.txt")
+	if err := report.Save(textFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(textFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "lost notes: 1 of 2 (flatten 1)\n"; !strings.HasPrefix(string(data), want) {
+		t.Errorf("text report %q, want it to start with %q", data, want)
+	}
+}
@@ -9,7 +9,22 @@ import (
 
 func main() {
 	args := os.Args[1:]
-	config := NewAutosirilConfig(args)
+	if len(args) > 0 && (args[0] == "suggest" || args[0] == "optimize") {
+		run := RunSuggest
+		if args[0] == "optimize" {
+			run = RunOptimize
+		}
+		if err := run(args[1:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	config, err := NewAutosirilConfig(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 	
 	fmt.Printf("chan_settings: %v\n", strings.Split(config.ChannelMapping, ","))
 	fmt.Println("Starting MIDI to VortexTracker conversion...")
@@ -23,6 +38,9 @@ func main() {
 	channelSettings, err := parseChannelMapping(config.ChannelMapping)
 	if err != nil {
 		fmt.Printf("Error parsing channel mapping: %v\n", err)
+		if mappingErr, ok := err.(*MappingError); ok {
+			fmt.Println(mappingErr.Context())
+		}
 		os.Exit(1)
 	}
 	
@@ -39,26 +57,40 @@ func main() {
 	// Detect key and transpose
 	keyProcessor := NewKeyProcessor(config)
 	detectedKey := keyProcessor.DetectKey(virtualNotes)
-	keyProcessor.TransposeNotes(virtualNotes, detectedKey)
+	keyProcessor.TransposeNotes(virtualNotes, detectedKey, channelSettings)
 	
-	// Flatten notes to timeline
-	polyphonicProcessor := NewPolyphonicProcessor(config)
-	timelines, ornamentGenerator, err := polyphonicProcessor.FlattenNotes(virtualNotes, maxRow, channelSettings)
+	// Envelope notes follow the chip clock and note table
+	tuning := NewEnvelopeTuning(config)
+	config.Tuning = tuning
+	
+	finalChannels, ornaments, lostNotes, err := convertMapping(config, channelSettings, virtualNotes, midiProcessor.DroppedNotes(), maxRow)
 	if err != nil {
 		fmt.Printf("Error flattening notes: %v\n", err)
 		os.Exit(1)
 	}
-	
-	// Generate ornaments
-	ornaments := ornamentGenerator.GenerateOrnaments(timelines)
-	
-	// Apply echo effects
-	echoProcessor := NewEchoProcessor(config)
-	timelines = echoProcessor.ApplyEcho(timelines, channelSettings)
-	
-	// Mix channels
-	channelMixer := NewChannelMixer(config)
-	finalChannels := channelMixer.MixChannels(timelines, channelSettings)
+	lostNotes.Report()
+	if config.LostNotesFile != "" {
+		if err := lostNotes.Save(config.LostNotesFile); err != nil {
+			fmt.Printf("Error writing lost-note report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Lost-note report written to %s\n", config.LostNotesFile)
+	}
+	tuning.Report(finalChannels)
+	if config.TuningReportFile != "" {
+		if err := tuning.Save(config.TuningReportFile); err != nil {
+			fmt.Printf("Error writing tuning report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tuning report written to %s\n", config.TuningReportFile)
+	}
+	if config.SaveOrnamentsFile != "" {
+		if err := SaveOrnamentTable(config.SaveOrnamentsFile, ornaments); err != nil {
+			fmt.Printf("Error writing ornament table: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Ornament table written to %s\n", config.SaveOrnamentsFile)
+	}
 	
 	// Generate output
 	outputGenerator := NewVortexOutputGenerator(config)
@@ -75,6 +107,37 @@ func main() {
 	fmt.Printf("Conversion complete: %s\n", outputFilename)
 }
 
+// convertMapping runs the flatten, echo and mix stages for one channel
+// mapping and returns the AY channels, their ornaments and the lost notes
+func convertMapping(config *AutosirilConfig, channelSettings [][]ChannelSettings, virtualNotes, droppedNotes []*VirtualNote, maxRow int) ([][]*VortexNote, []Ornament, *LostNoteReport, error) {
+	// Flatten notes to timeline
+	polyphonicProcessor := NewPolyphonicProcessor(config)
+	timelines, ornamentGenerator, err := polyphonicProcessor.FlattenNotes(virtualNotes, maxRow, channelSettings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pool := polyphonicProcessor.CollectPool(virtualNotes, channelSettings)
+	lostNotes := NewLostNoteReport(config)
+	lostNotes.AddGraceNotes(droppedNotes)
+	lostNotes.CheckFlattened(virtualNotes, timelines, channelSettings)
+
+	// Generate ornaments
+	ornamentGenerator.GenerateOrnaments(timelines)
+
+	// Apply echo effects
+	echoProcessor := NewEchoProcessor(config)
+	timelines, routedEchoes := echoProcessor.ApplyEcho(timelines, channelSettings)
+
+	// Mix channels, which may fold colliding voices into further ornaments
+	channelMixer := NewChannelMixer(config, ornamentGenerator)
+	finalChannels := channelMixer.MixChannels(timelines, channelSettings, routedEchoes)
+	channelMixer.AllocateVoices(finalChannels, pool)
+	channelMixer.ArbitrateEnvelopes(finalChannels)
+	lostNotes.CheckMixed(finalChannels)
+	return finalChannels, ornamentGenerator.Ornaments(), lostNotes, nil
+}
+
 func generateOutputFilename(inputFile string, transpose int) string {
 	base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
 	if transpose != 0 {
@@ -86,119 +149,3 @@ func generateOutputFilename(inputFile string, transpose int) string {
 func writeOutputFile(filename, content string) error {
 	return os.WriteFile(filename, []byte(content), 0644)
 }
-
-// Simplified channel mapping parser
-func parseChannelMapping(mapping string) ([][]ChannelSettings, error) {
-	ayChannels := strings.Split(mapping, ",")
-	result := make([][]ChannelSettings, len(ayChannels))
-	
-	for ayIdx, ayChannel := range ayChannels {
-		midiChannels := strings.Split(ayChannel, "-")
-		result[ayIdx] = make([]ChannelSettings, len(midiChannels))
-		
-		for midiIdx, midiChannel := range midiChannels {
-			setting, err := parseChannelSetting(midiChannel)
-			if err != nil {
-				return nil, err
-			}
-			result[ayIdx][midiIdx] = setting
-		}
-	}
-	
-	return result, nil
-}
-
-func parseChannelSetting(setting string) (ChannelSettings, error) {
-	// Parse channel mapping syntax: channel[type][modifiers][samples/ornaments][mix_option]
-	// Examples: 2me, 3m-7m-6p+, 2me[2f]-6p[3]+, 1d-2me-3p, 4m[uf]-5m[2]+
-	
-	result := ChannelSettings{
-		Sample:   2, // Default sample is 2 in Ruby
-		Ornament: 0,
-		MixOption: "-", // Default mix option
-	}
-	
-	// Remove any '+' at the end and save it
-	if strings.HasSuffix(setting, "+") {
-		result.MixOption = "+"
-		setting = strings.TrimSuffix(setting, "+")
-	}
-	
-	// Extract channel number (convert to 0-based like Ruby)
-	i := 0
-	for i < len(setting) && setting[i] >= '0' && setting[i] <= '9' {
-		result.MIDIChannel = result.MIDIChannel*10 + int(setting[i]-'0')
-		i++
-	}
-	// Channel numbers in mapping are 1-based, keep them as-is since MIDI tracks are numbered starting from 0
-	// but mapping "1p" should use track 1, "2m" should use track 2, etc.
-	
-	// Extract instrument type and modifiers
-	if i < len(setting) {
-		switch setting[i] {
-		case 'd':
-			result.InstrumentType = "d"
-			i++
-		case 'm':
-			result.InstrumentType = "m"
-			i++
-			// Check for 'e' after 'm'
-			if i < len(setting) && setting[i] == 'e' {
-				result.InstrumentType = "e"
-				i++
-			}
-			// Check for 'u' or 'w' modifiers
-			for i < len(setting) && (setting[i] == 'u' || setting[i] == 'w') {
-				result.Modifiers += string(setting[i])
-				i++
-			}
-		case 'p':
-			result.InstrumentType = "p"
-			i++
-		case 'e':
-			result.InstrumentType = "e"
-			i++
-		}
-	}
-	
-	// Extract sample/ornament if present in [SO] format
-	if i < len(setting) && setting[i] == '[' {
-		i++ // Skip '['
-		
-		// Parse sample (first hex digit)
-		if i < len(setting) {
-			if val, ok := parseHexChar(setting[i]); ok {
-				result.Sample = val
-				i++
-			}
-		}
-		
-		// Parse ornament (second hex digit)
-		if i < len(setting) && setting[i] != ']' {
-			if val, ok := parseHexChar(setting[i]); ok {
-				result.Ornament = val
-				i++
-			}
-		}
-		
-		// Skip closing bracket
-		if i < len(setting) && setting[i] == ']' {
-			i++
-		}
-	}
-	
-	return result, nil
-}
-
-func parseHexChar(ch byte) (int, bool) {
-	if ch >= '0' && ch <= '9' {
-		return int(ch - '0'), true
-	}
-	if ch >= 'a' && ch <= 'f' {
-		return int(ch - 'a' + 10), true
-	}
-	if ch >= 'A' && ch <= 'F' {
-		return int(ch - 'A' + 10), true
-	}
-	return 0, false
-}
\ No newline at end of file
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Channel mapping grammar:
+//
+//	mapping  = channel { "," channel }
+//	channel  = entry { "-" entry }
+//	entry    = track [ kind ] { flag } [ ids ] [ options ] [ "+" ]
+//	track    = digit { digit }
+//	kind     = "m" | "p" | "d" | "e"
+//	flag     = "e" | "u" | "w" | "r"
+//	ids      = "[" id [ id ] "]"
+//	id       = param | "{" digit { digit } "}"
+//	param    = "0" | "." | "1".."9" | "A".."V"   (case-insensitive)
+//	options  = "{" option { "," option } "}"
+//	option   = name [ "=" value ]
+//
+// The first id selects the sample (1-31), the second the ornament (0-15).
+// A missing kind defaults to "m" like in Ruby. Whitespace is ignored.
+// Option names and values are listed in channelOptions.
+
+// MappingError reports a channel mapping syntax error at a 1-based column
+type MappingError struct {
+	Input  string
+	Column int
+	Msg    string
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// Context returns the mapping with a caret under the offending column
+func (e *MappingError) Context() string {
+	return fmt.Sprintf("  %s\n  %s^", e.Input, strings.Repeat(" ", e.Column-1))
+}
+
+type mappingTokenKind int
+
+const (
+	tokEOF mappingTokenKind = iota
+	tokNumber
+	tokLetter
+	tokID
+	tokLBrack
+	tokRBrack
+	tokPlus
+	tokDash
+	tokComma
+	tokLBrace
+	tokRBrace
+	tokWord
+	tokEquals
+)
+
+type mappingToken struct {
+	kind   mappingTokenKind
+	text   string
+	value  int // tokNumber, tokID
+	column int
+}
+
+// describe returns the token as it should appear in an error message
+func (t mappingToken) describe() string {
+	if t.kind == tokEOF {
+		return "end of mapping"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// tokenizeMapping splits a mapping into tokens. Inside "[...]" every
+// character is a separate id token so that "[12]" means sample 1, ornament 2.
+// Inside "{...}" letters and digits form words.
+func tokenizeMapping(input string) ([]mappingToken, error) {
+	var tokens []mappingToken
+	inIDs := false
+	inOptions := false
+	i := 0
+	for i < len(input) {
+		ch := input[i]
+		col := i + 1
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case inOptions:
+			switch {
+			case ch == '}':
+				inOptions = false
+				tokens = append(tokens, mappingToken{kind: tokRBrace, text: "}", column: col})
+				i++
+			case ch == '=':
+				tokens = append(tokens, mappingToken{kind: tokEquals, text: "=", column: col})
+				i++
+			case ch == ',':
+				tokens = append(tokens, mappingToken{kind: tokComma, text: ",", column: col})
+				i++
+			case isOptionWordChar(ch):
+				start := i
+				for i < len(input) && isOptionWordChar(input[i]) {
+					i++
+				}
+				tokens = append(tokens, mappingToken{kind: tokWord, text: input[start:i], column: col})
+			default:
+				return nil, &MappingError{input, col, fmt.Sprintf("unexpected character '%c' in options", ch)}
+			}
+		case !inIDs && ch == '{':
+			inOptions = true
+			tokens = append(tokens, mappingToken{kind: tokLBrace, text: "{", column: col})
+			i++
+		case ch == '[':
+			if inIDs {
+				return nil, &MappingError{input, col, "nested '['"}
+			}
+			inIDs = true
+			tokens = append(tokens, mappingToken{kind: tokLBrack, text: "[", column: col})
+			i++
+		case ch == ']':
+			if !inIDs {
+				return nil, &MappingError{input, col, "unmatched ']'"}
+			}
+			inIDs = false
+			tokens = append(tokens, mappingToken{kind: tokRBrack, text: "]", column: col})
+			i++
+		case inIDs && ch == '{':
+			end := strings.IndexByte(input[i:], '}')
+			if end < 0 {
+				return nil, &MappingError{input, col, "unterminated '{'"}
+			}
+			text := input[i : i+end+1]
+			val, err := strconv.Atoi(text[1 : len(text)-1])
+			if err != nil || val < 0 {
+				return nil, &MappingError{input, col + 1, fmt.Sprintf("expected decimal id in %s", text)}
+			}
+			tokens = append(tokens, mappingToken{kind: tokID, text: text, value: val, column: col})
+			i += end + 1
+		case inIDs:
+			val, ok := parseParamChar(ch)
+			if !ok {
+				return nil, &MappingError{input, col, fmt.Sprintf("invalid id '%c' (expected 0-9, A-V or {decimal})", ch)}
+			}
+			tokens = append(tokens, mappingToken{kind: tokID, text: string(ch), value: val, column: col})
+			i++
+		case ch >= '0' && ch <= '9':
+			start := i
+			for i < len(input) && input[i] >= '0' && input[i] <= '9' {
+				i++
+			}
+			val, _ := strconv.Atoi(input[start:i])
+			tokens = append(tokens, mappingToken{kind: tokNumber, text: input[start:i], value: val, column: col})
+		case ch >= 'a' && ch <= 'z':
+			tokens = append(tokens, mappingToken{kind: tokLetter, text: string(ch), column: col})
+			i++
+		case ch == '+':
+			tokens = append(tokens, mappingToken{kind: tokPlus, text: "+", column: col})
+			i++
+		case ch == '-':
+			tokens = append(tokens, mappingToken{kind: tokDash, text: "-", column: col})
+			i++
+		case ch == ',':
+			tokens = append(tokens, mappingToken{kind: tokComma, text: ",", column: col})
+			i++
+		default:
+			return nil, &MappingError{input, col, fmt.Sprintf("unexpected character '%c'", ch)}
+		}
+	}
+	if inIDs {
+		return nil, &MappingError{input, len(input) + 1, "missing ']'"}
+	}
+	if inOptions {
+		return nil, &MappingError{input, len(input) + 1, "missing '}'"}
+	}
+	tokens = append(tokens, mappingToken{kind: tokEOF, column: len(input) + 1})
+	return tokens, nil
+}
+
+func isOptionWordChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '.' || ch == '_' ||
+		ch == '-' || ch == ':' || ch == '/'
+}
+
+// parseParamChar decodes a single sample/ornament id using the Params alphabet
+func parseParamChar(ch byte) (int, bool) {
+	if ch == '0' {
+		return 0, true
+	}
+	if ch >= 'a' && ch <= 'z' {
+		ch -= 'a' - 'A'
+	}
+	val, ok := ParamMap[string(ch)]
+	return val, ok
+}
+
+// mappingParser is a recursive descent parser over mapping tokens
+type mappingParser struct {
+	input  string
+	tokens []mappingToken
+	pos    int
+}
+
+func (p *mappingParser) peek() mappingToken {
+	return p.tokens[p.pos]
+}
+
+func (p *mappingParser) next() mappingToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *mappingParser) errorf(tok mappingToken, format string, args ...interface{}) error {
+	return &MappingError{Input: p.input, Column: tok.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseChannelMapping parses the full mapping into per-AY-channel settings
+func parseChannelMapping(mapping string) ([][]ChannelSettings, error) {
+	tokens, err := tokenizeMapping(mapping)
+	if err != nil {
+		return nil, err
+	}
+	p := &mappingParser{input: mapping, tokens: tokens}
+
+	var result [][]ChannelSettings
+	for {
+		start := p.peek()
+		channel, err := p.parseChannel()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range channel {
+			if entry.PingPong == len(result) {
+				return nil, p.errorf(start, "AY channel %c cannot ping-pong echo into itself", 'A'+len(result))
+			}
+		}
+		result = append(result, channel)
+
+		tok := p.next()
+		switch tok.kind {
+		case tokComma:
+			if len(result) == 3 {
+				return nil, p.errorf(tok, "too many AY channels, the chip only has 3")
+			}
+		case tokEOF:
+			return result, nil
+		default:
+			return nil, p.errorf(tok, "unexpected %s (expected '-', ',' or end of mapping)", tok.describe())
+		}
+	}
+}
+
+func (p *mappingParser) parseChannel() ([]ChannelSettings, error) {
+	var entries []ChannelSettings
+	for {
+		entry, err := p.parseEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		if p.peek().kind != tokDash {
+			return entries, nil
+		}
+		p.next()
+	}
+}
+
+func (p *mappingParser) parseEntry() (ChannelSettings, error) {
+	result := ChannelSettings{
+		InstrumentType: "m", // Ruby defaults to monophonic
+		Sample:         2,   // Default sample is 2 in Ruby
+		Ornament:       0,
+		MixOption:      "-",
+		EchoOrnament:   -1,
+		PingPong:       -1,
+	}
+
+	tok := p.next()
+	if tok.kind != tokNumber {
+		return result, p.errorf(tok, "expected track number, got %s", tok.describe())
+	}
+	result.MIDIChannel = tok.value
+
+	// Instrument kind followed by flags
+	if tok := p.peek(); tok.kind == tokLetter {
+		switch tok.text {
+		case "m", "p", "d", "e":
+			result.InstrumentType = tok.text
+			p.next()
+		}
+	}
+	for p.peek().kind == tokLetter {
+		tok := p.next()
+		switch tok.text {
+		case "e":
+			if result.InstrumentType == "e" {
+				return result, p.errorf(tok, "duplicate envelope flag 'e'")
+			}
+			if result.InstrumentType != "m" {
+				return result, p.errorf(tok, "envelope flag 'e' is only valid on 'm' channels, not '%s'", result.InstrumentType)
+			}
+			result.InstrumentType = "e"
+		case "u", "w", "r":
+			if tok.text == "r" && result.InstrumentType != "p" {
+				return result, p.errorf(tok, "retrigger modifier 'r' is only valid on 'p' channels")
+			}
+			if strings.Contains(result.Modifiers, tok.text) {
+				return result, p.errorf(tok, "duplicate modifier '%s'", tok.text)
+			}
+			result.Modifiers += tok.text
+		case "m", "p", "d":
+			return result, p.errorf(tok, "instrument kind '%s' must directly follow the track number", tok.text)
+		default:
+			return result, p.errorf(tok, "unknown modifier '%s'", tok.text)
+		}
+	}
+
+	if p.peek().kind == tokLBrack {
+		if err := p.parseIDs(&result); err != nil {
+			return result, err
+		}
+	}
+
+	if open := p.peek(); open.kind == tokLBrace {
+		if err := p.parseOptions(&result); err != nil {
+			return result, err
+		}
+		taps := 2 // PER_DELAY and PER_DELAY2
+		if result.EchoDelays != nil {
+			taps = len(result.EchoDelays)
+		}
+		if n := len(result.EchoDecay); n > 1 && n != taps {
+			return result, p.errorf(open, "decay lists %d levels for %d echo taps", n, taps)
+		}
+		if result.MuxDuty[0] != 0 && result.Mux == "" {
+			return result, p.errorf(open, "option 'duty' needs 'mux'")
+		}
+		if result.Mux != "" && result.Steal {
+			return result, p.errorf(open, "options 'mux' and 'steal' exclude each other")
+		}
+		if result.Pool != "" && result.Mux != "" {
+			return result, p.errorf(open, "options 'pool' and 'mux' exclude each other")
+		}
+	}
+
+	if p.peek().kind == tokPlus {
+		p.next()
+		result.MixOption = "+"
+	}
+
+	return result, nil
+}
+
+func (p *mappingParser) parseIDs(result *ChannelSettings) error {
+	open := p.next()
+	tok := p.next()
+	if tok.kind != tokID {
+		return p.errorf(tok, "expected sample id after '['")
+	}
+	if tok.value < 1 || tok.value >= len(Params) {
+		return p.errorf(tok, "sample %d out of range 1-%d", tok.value, len(Params)-1)
+	}
+	result.Sample = tok.value
+
+	if tok = p.peek(); tok.kind == tokID {
+		p.next()
+		if tok.value > 15 {
+			return p.errorf(tok, "ornament %d out of range 0-15", tok.value)
+		}
+		result.Ornament = tok.value
+	}
+
+	if tok = p.next(); tok.kind != tokRBrack {
+		if tok.kind == tokID {
+			return p.errorf(tok, "too many ids in '[' at column %d (expected sample and ornament)", open.column)
+		}
+		return p.errorf(tok, "expected ']', got %s", tok.describe())
+	}
+	return nil
+}
+
+// channelOptions holds the setters for "{name=value}" entry options.
+// Options without "=value" receive an empty value.
+var channelOptions = map[string]func(setting *ChannelSettings, value string) error{
+	"prio": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "m" && setting.InstrumentType != "e" {
+			return fmt.Errorf("option 'prio' is only valid on 'm' and 'e' channels")
+		}
+		switch value {
+		case PriorityHigh, PriorityLow, PriorityLast, PriorityFirst, PriorityLoud:
+			setting.Priority = value
+			return nil
+		}
+		return fmt.Errorf("unknown priority '%s' (expected high, low, last, first or loud)", value)
+	},
+	"resume": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "m" && setting.InstrumentType != "e" {
+			return fmt.Errorf("option 'resume' is only valid on 'm' and 'e' channels")
+		}
+		if value != "" {
+			return fmt.Errorf("option 'resume' takes no value")
+		}
+		setting.ResumeHeld = true
+		return nil
+	},
+	"attack": parseAttackOption,
+	"echo": func(setting *ChannelSettings, value string) error {
+		return parseEchoOption(setting, "echo", value)
+	},
+	"decay": func(setting *ChannelSettings, value string) error {
+		return parseEchoOption(setting, "decay", value)
+	},
+	"cutoff": func(setting *ChannelSettings, value string) error {
+		return parseEchoOption(setting, "cutoff", value)
+	},
+	"pingpong": parsePingPongOption,
+	"echoprio": parseEchoPriority,
+	"echosample": func(setting *ChannelSettings, value string) error {
+		return parseEchoOption(setting, "echosample", value)
+	},
+	"echoorn": func(setting *ChannelSettings, value string) error {
+		return parseEchoOption(setting, "echoorn", value)
+	},
+	"collapse": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "m" && setting.InstrumentType != "e" {
+			return fmt.Errorf("option 'collapse' is only valid on 'm' and 'e' channels")
+		}
+		if value != "" {
+			return fmt.Errorf("option 'collapse' takes no value")
+		}
+		setting.Collapse = true
+		return nil
+	},
+	"mux": func(setting *ChannelSettings, value string) error {
+		return parseMuxOption(setting, "mux", value)
+	},
+	"duty": func(setting *ChannelSettings, value string) error {
+		return parseMuxOption(setting, "duty", value)
+	},
+	"steal": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "d" {
+			return fmt.Errorf("option 'steal' is only valid on 'd' channels")
+		}
+		setting.Steal = true
+		if value == "" {
+			return nil
+		}
+		rows, err := strconv.Atoi(value)
+		if err != nil || rows < 1 {
+			return fmt.Errorf("steal expects a row count of at least 1, got '%s'", value)
+		}
+		setting.StealRows = rows
+		return nil
+	},
+	"pool": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "m" && setting.InstrumentType != "p" {
+			return fmt.Errorf("option 'pool' is only valid on 'm' and 'p' channels")
+		}
+		switch value {
+		case "", PoolStealAge:
+			setting.Pool = PoolStealAge
+		case PoolStealVolume:
+			setting.Pool = PoolStealVolume
+		default:
+			return fmt.Errorf("unknown pool stealing policy '%s' (expected age or volume)", value)
+		}
+		return nil
+	},
+	"envprio": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "e" {
+			return fmt.Errorf("option 'envprio' is only valid on 'e' channels")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("envprio expects a priority of at least 0, got '%s'", value)
+		}
+		setting.EnvPriority = n
+		return nil
+	},
+	"drumprio": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "d" {
+			return fmt.Errorf("option 'drumprio' is only valid on 'd' channels")
+		}
+		order, err := parseDrumPriority(value)
+		if err != nil {
+			return err
+		}
+		setting.DrumPriority = order
+		return nil
+	},
+	"voicing": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "p" {
+			return fmt.Errorf("option 'voicing' is only valid on 'p' channels")
+		}
+		switch value {
+		case VoicingRoot, VoicingClosest:
+			setting.Voicing = value
+			return nil
+		}
+		return fmt.Errorf("unknown voicing '%s' (expected root or closest)", value)
+	},
+	"outliers": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "p" {
+			return fmt.Errorf("option 'outliers' is only valid on 'p' channels")
+		}
+		switch value {
+		case OutliersDrop, OutliersFold:
+			setting.Outliers = value
+			return nil
+		}
+		return fmt.Errorf("unknown outlier policy '%s' (expected drop or fold)", value)
+	},
+	"arp": func(setting *ChannelSettings, value string) error {
+		return parseArpOption(setting, "arp", value)
+	},
+	"rate": func(setting *ChannelSettings, value string) error {
+		return parseArpOption(setting, "rate", value)
+	},
+	"loop": func(setting *ChannelSettings, value string) error {
+		return parseArpOption(setting, "loop", value)
+	},
+	"seed": func(setting *ChannelSettings, value string) error {
+		return parseArpOption(setting, "seed", value)
+	},
+	"spill": func(setting *ChannelSettings, value string) error {
+		if setting.InstrumentType != "d" {
+			return fmt.Errorf("option 'spill' is only valid on 'd' channels")
+		}
+		if value == "" {
+			setting.DrumSpill = 1
+			return nil
+		}
+		rows, err := strconv.Atoi(value)
+		if err != nil || rows < 1 {
+			return fmt.Errorf("spill expects a row count of at least 1, got '%s'", value)
+		}
+		setting.DrumSpill = rows
+		return nil
+	},
+}
+
+func (p *mappingParser) parseOptions(result *ChannelSettings) error {
+	p.next() // '{'
+	seen := make(map[string]bool)
+	for {
+		name := p.next()
+		if name.kind != tokWord {
+			return p.errorf(name, "expected option name, got %s", name.describe())
+		}
+		setter, ok := channelOptions[name.text]
+		if !ok {
+			return p.errorf(name, "unknown option '%s'", name.text)
+		}
+		if seen[name.text] {
+			return p.errorf(name, "duplicate option '%s'", name.text)
+		}
+		seen[name.text] = true
+
+		value := mappingToken{column: name.column + len(name.text)}
+		if p.peek().kind == tokEquals {
+			p.next()
+			value = p.next()
+			if value.kind != tokWord {
+				return p.errorf(value, "expected value for option '%s', got %s", name.text, value.describe())
+			}
+		}
+		if err := setter(result, value.text); err != nil {
+			return p.errorf(value, "%v", err)
+		}
+
+		tok := p.next()
+		switch tok.kind {
+		case tokComma:
+			continue
+		case tokRBrace:
+			return nil
+		default:
+			return p.errorf(tok, "expected ',' or '}', got %s", tok.describe())
+		}
+	}
+}
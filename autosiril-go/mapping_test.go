@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseChannelMapping(t *testing.T) {
+	tests := []struct {
+		mapping  string
+		channels []int
+		entry    [2]int // channel, entry index of the checked entry
+		track    int
+		kind     string
+		mods     string
+		sample   int
+		ornament int
+		mix      string
+	}{
+		{"1", []int{1}, [2]int{0, 0}, 1, "m", "", 2, 0, "-"},
+		{"1d-2me-3p,4m", []int{3, 1}, [2]int{0, 1}, 2, "e", "", 2, 0, "-"},
+		{"1d-2me-3p,4m[uf]-5m[2]+", []int{3, 2}, [2]int{1, 0}, 4, "m", "", 30, 15, "-"},
+		{"1d-2me-3p,4m[uf]-5m[2]+", []int{3, 2}, [2]int{1, 1}, 5, "m", "", 2, 0, "+"},
+		{"1,2,12pw[{17}{3}]", []int{1, 1, 1}, [2]int{2, 0}, 12, "p", "w", 17, 3, "-"},
+		{" 3 m u w [ 4 1 ] + ", []int{1}, [2]int{0, 0}, 3, "m", "uw", 4, 1, "+"},
+		{"7eu", []int{1}, [2]int{0, 0}, 7, "e", "u", 2, 0, "-"},
+	}
+	for _, tt := range tests {
+		result, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.mapping, err)
+			continue
+		}
+		if len(result) != len(tt.channels) {
+			t.Errorf("%q: got %d channels, want %d", tt.mapping, len(result), len(tt.channels))
+			continue
+		}
+		for i, n := range tt.channels {
+			if len(result[i]) != n {
+				t.Errorf("%q: channel %d has %d entries, want %d", tt.mapping, i, len(result[i]), n)
+			}
+		}
+		got := result[tt.entry[0]][tt.entry[1]]
+		if got.MIDIChannel != tt.track || got.InstrumentType != tt.kind || got.Modifiers != tt.mods ||
+			got.Sample != tt.sample || got.Ornament != tt.ornament || got.MixOption != tt.mix {
+			t.Errorf("%q: entry %v = {%d %q %q %d %d %q}, want {%d %q %q %d %d %q}", tt.mapping, tt.entry,
+				got.MIDIChannel, got.InstrumentType, got.Modifiers, got.Sample, got.Ornament, got.MixOption,
+				tt.track, tt.kind, tt.mods, tt.sample, tt.ornament, tt.mix)
+		}
+	}
+}
+
+func TestParseChannelMappingErrors(t *testing.T) {
+	tests := []struct {
+		mapping string
+		column  int
+		msg     string
+	}{
+		{"", 1, "expected track number, got end of mapping"},
+		{"1d-2x", 5, "unknown modifier 'x'"},
+		{"1,2,3,4", 6, "too many AY channels, the chip only has 3"},
+		{"1-", 3, "expected track number, got end of mapping"},
+		{"1m2", 3, "unexpected '2' (expected '-', ',' or end of mapping)"},
+		{"1ee", 3, "duplicate envelope flag 'e'"},
+		{"1pe", 3, "envelope flag 'e' is only valid on 'm' channels, not 'p'"},
+		{"1uu", 3, "duplicate modifier 'u'"},
+		{"1mr", 3, "retrigger modifier 'r' is only valid on 'p' channels"},
+		{"1prr", 4, "duplicate modifier 'r'"},
+		{"1ud", 3, "instrument kind 'd' must directly follow the track number"},
+		{"1[]", 3, "expected sample id after '['"},
+		{"1[0]", 3, "sample 0 out of range 1-31"},
+		{"1[{40}]", 3, "sample 40 out of range 1-31"},
+		{"1[2{16}]", 4, "ornament 16 out of range 0-15"},
+		{"1[123]", 5, "too many ids in '[' at column 2 (expected sample and ornament)"},
+		{"1[2", 4, "missing ']'"},
+		{"1]", 2, "unmatched ']'"},
+		{"1[[", 3, "nested '['"},
+		{"1[{2", 3, "unterminated '{'"},
+		{"1[{x}]", 4, "expected decimal id in {x}"},
+		{"1[W]", 3, "invalid id 'W' (expected 0-9, A-V or {decimal})"},
+		{"1M", 2, "unexpected character 'M'"},
+		{"1{}", 3, "expected option name, got '}'"},
+		{"1{foo}", 3, "unknown option 'foo'"},
+		{"1{prio=mid}", 8, "unknown priority 'mid' (expected high, low, last, first or loud)"},
+		{"1{prio=}", 8, "expected value for option 'prio', got '}'"},
+		{"1{resume=1}", 10, "option 'resume' takes no value"},
+		{"1{resume,resume}", 10, "duplicate option 'resume'"},
+		{"1{prio=low;}", 11, "unexpected character ';' in options"},
+		{"1d{prio=low}", 9, "option 'prio' is only valid on 'm' and 'e' channels"},
+		{"1p{resume}", 10, "option 'resume' is only valid on 'm' and 'e' channels"},
+		{"1{prio", 7, "missing '}'"},
+		{"1m{voicing=root}", 12, "option 'voicing' is only valid on 'p' channels"},
+		{"1p{voicing=open}", 12, "unknown voicing 'open' (expected root or closest)"},
+		{"1d{outliers=fold}", 13, "option 'outliers' is only valid on 'p' channels"},
+		{"1p{outliers=keep}", 13, "unknown outlier policy 'keep' (expected drop or fold)"},
+		{"1m{arp=up}", 8, "option 'arp' is only valid on 'p' channels"},
+		{"1p{arp=zigzag}", 8, "unknown arpeggio 'zigzag' (expected up, down, updown, downup, random or chord)"},
+		{"1p{rate=fast}", 9, "option 'rate' expects a number, got 'fast'"},
+		{"1p{rate=17}", 9, "rate 17 out of range 1-16 frames"},
+		{"1p{collapse}", 12, "option 'collapse' is only valid on 'm' and 'e' channels"},
+		{"1p{attack=12.0}", 11, "option 'attack' is not valid on 'p' channels"},
+		{"1m{attack=kit}", 11, "drum attacks 'kit' are only valid on 'd' channels"},
+		{"1d{attack=bass:12.0}", 11, "unknown drum class 'bass' (expected kick, snare, clap, tom, perc, hat, cymbal)"},
+		{"1m{attack=12,0}", 14, "unknown option '0'"},
+		{"1m{attack=60.0}", 11, "attack offset 60 out of range -48..48"},
+		{"1m{echo=0}", 9, "echo delay 0 out of range 1-64 rows"},
+		{"1m{echo=3.x}", 9, "option 'echo' expects dot-separated numbers, got '3.x'"},
+		{"1m{decay=101}", 10, "decay 101 out of range 1-100 percent"},
+		{"1m{decay=70.50.30}", 3, "decay lists 3 levels for 2 echo taps"},
+		{"1mu{pingpong=b}", 14, "option 'pingpong' needs echo, but the entry has the 'u' modifier"},
+		{"1m{pingpong=d}", 13, "pingpong expects an AY channel a, b or c, got 'd'"},
+		{"1m,2m{pingpong=b}", 4, "AY channel B cannot ping-pong echo into itself"},
+		{"1m{echoprio=late}", 13, "unknown echo priority 'late' (expected original, release or sustain:N)"},
+		{"1m{echoprio=sustain}", 13, "echoprio=sustain expects the rows a note keeps before echoes may cut it, like sustain:4"},
+		{"1m{cutoff=16}", 11, "cutoff expects a volume 1-15, got '16'"},
+		{"1m{echosample=0}", 15, "sample 0 out of range 1-31"},
+		{"1m{echoorn=xyz}", 12, "option 'echoorn': expected an id like 'F' or a number, got 'xyz'"},
+		{"1m{spill}", 9, "option 'spill' is only valid on 'd' channels"},
+		{"1m{steal}", 9, "option 'steal' is only valid on 'd' channels"},
+		{"1d{steal=0}", 10, "steal expects a row count of at least 1, got '0'"},
+		{"1m{mux=fast}", 8, "unknown mux mode 'fast' (expected rows or frames)"},
+		{"1m{mux,duty=2}", 13, "duty expects two counts like 2.1, got '2'"},
+		{"1m{mux,duty=2.17}", 13, "duty count '17' out of range 1-16"},
+		{"1m{duty=2.1}", 3, "option 'duty' needs 'mux'"},
+		{"1d{mux,steal}", 3, "options 'mux' and 'steal' exclude each other"},
+		{"1d{pool}", 8, "option 'pool' is only valid on 'm' and 'p' channels"},
+		{"1p{pool=random}", 9, "unknown pool stealing policy 'random' (expected age or volume)"},
+		{"1m{pool,mux}", 3, "options 'pool' and 'mux' exclude each other"},
+		{"1m{envprio=1}", 12, "option 'envprio' is only valid on 'e' channels"},
+		{"1e{envprio=-1}", 12, "envprio expects a priority of at least 0, got '-1'"},
+		{"1d{spill=0}", 10, "spill expects a row count of at least 1, got '0'"},
+		{"1p{drumprio=kick}", 13, "option 'drumprio' is only valid on 'd' channels"},
+		{"1d{drumprio=kick.bass}", 13, "unknown drum class 'bass' (expected kick, snare, clap, tom, perc, hat, cymbal)"},
+	}
+	for _, tt := range tests {
+		_, err := parseChannelMapping(tt.mapping)
+		var mappingErr *MappingError
+		if !errors.As(err, &mappingErr) {
+			t.Errorf("%q: got %v, want a MappingError", tt.mapping, err)
+			continue
+		}
+		if mappingErr.Column != tt.column || mappingErr.Msg != tt.msg {
+			t.Errorf("%q: got column %d %q, want column %d %q", tt.mapping, mappingErr.Column, mappingErr.Msg, tt.column, tt.msg)
+		}
+	}
+}
+
+func TestParseChannelMappingOptions(t *testing.T) {
+	tests := []struct {
+		mapping  string
+		priority string
+		resume   bool
+		mix      string
+	}{
+		{"1", "", false, "-"},
+		{"1{prio=low}", PriorityLow, false, "-"},
+		{"1m[2]{prio=last, resume}+", PriorityLast, true, "+"},
+		{"1{resume}", "", true, "-"},
+	}
+	for _, tt := range tests {
+		result, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.mapping, err)
+			continue
+		}
+		got := result[0][0]
+		if got.Priority != tt.priority || got.ResumeHeld != tt.resume || got.MixOption != tt.mix {
+			t.Errorf("%q: got {%q %v %q}, want {%q %v %q}", tt.mapping,
+				got.Priority, got.ResumeHeld, got.MixOption, tt.priority, tt.resume, tt.mix)
+		}
+	}
+}
+
+func TestMappingErrorContext(t *testing.T) {
+	err := &MappingError{Input: "1d-2x", Column: 5, Msg: "unknown modifier 'x'"}
+	if got, want := err.Error(), "column 5: unknown modifier 'x'"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got, want := err.Context(), "  1d-2x\n      ^"; got != want {
+		t.Errorf("Context() = %q, want %q", got, want)
+	}
+}
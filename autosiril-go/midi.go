@@ -10,7 +10,8 @@ import (
 
 // MidiProcessor handles MIDI file loading and note extraction
 type MidiProcessor struct {
-	config *AutosirilConfig
+	config  *AutosirilConfig
+	dropped []*VirtualNote // sub-row notes discarded by the grace policy
 }
 
 func NewMidiProcessor(config *AutosirilConfig) *MidiProcessor {
@@ -32,6 +33,7 @@ func (mp *MidiProcessor) LoadMIDI() ([]*VirtualNote, int, error) {
 
 	var virtualNotes []*VirtualNote
 	var maxRow int
+	graceStats := &GraceStats{}
 
 	// Calculate clocks per row for timing conversion
 	ticksPerQuarter := int(smfFile.TimeFormat.(smf.MetricTicks))
@@ -40,57 +42,49 @@ func (mp *MidiProcessor) LoadMIDI() ([]*VirtualNote, int, error) {
 	// Process each track
 	for trackIdx, track := range smfFile.Tracks {
 		fmt.Printf("track , num_tracks %d, index %d\n", len(smfFile.Tracks), trackIdx)
-		
+
 		// Track active notes for note-off events
 		activeNotes := make(map[int]*VirtualNote) // key: note number
+		var trackNotes []*VirtualNote
 		currentTime := 0
 
+		finishNote := func(activeNote *VirtualNote) {
+			activeNote.Off = int(float64(currentTime)/float64(clocksPerRow) + 0.5) // Round to nearest like Ruby
+			activeNote.Length = activeNote.Off - activeNote.Start
+			trackNotes = append(trackNotes, activeNote)
+		}
+
 		// Process messages in track
 		for _, event := range track {
 			// Update current time based on delta
 			currentTime += int(event.Delta)
 
 			var channel, key, velocity uint8
-			
+
 			// Handle note on messages
 			if event.Message.GetNoteOn(&channel, &key, &velocity) {
 				if velocity > 0 {
 					// Note on
 					trackerRow := int(float64(currentTime)/float64(clocksPerRow) + 0.5) // Round to nearest like Ruby
 					note := &VirtualNote{
-						Note:    int(key),
-						Volume:  15, // Ruby always uses 15, ignoring velocity
-						Start:   trackerRow,
-						Channel: trackIdx, // Use track index like Ruby, not MIDI channel!
+						Note:     int(key),
+						Volume:   15, // Ruby always uses 15, ignoring velocity
+						Velocity: int(velocity),
+						Start:    trackerRow,
+						Channel:  trackIdx, // Use track index like Ruby, not MIDI channel!
+						Tick:     currentTime,
+						GMDrums:  channel == 9,
 					}
 					activeNotes[int(key)] = note
-				} else {
+				} else if activeNote, exists := activeNotes[int(key)]; exists {
 					// Note on with velocity 0 = note off
-					if activeNote, exists := activeNotes[int(key)]; exists {
-						trackerRow := int(float64(currentTime)/float64(clocksPerRow) + 0.5)
-						activeNote.Off = trackerRow
-						activeNote.Length = activeNote.Off - activeNote.Start
-						if activeNote.Length > 0 {
-							virtualNotes = append(virtualNotes, activeNote)
-							if activeNote.Off > maxRow {
-								maxRow = activeNote.Off
-							}
-						}
-						delete(activeNotes, int(key))
-					}
+					finishNote(activeNote)
+					delete(activeNotes, int(key))
 				}
 			} else if event.Message.GetNoteOff(&channel, &key, &velocity) {
 				// Note off
 				if activeNote, exists := activeNotes[int(key)]; exists {
-					trackerRow := int(float64(currentTime)/float64(clocksPerRow) + 0.5)
-					activeNote.Off = trackerRow
-					activeNote.Length = activeNote.Off - activeNote.Start
-					if activeNote.Length > 0 {
-						virtualNotes = append(virtualNotes, activeNote)
-						if activeNote.Off > maxRow {
-							maxRow = activeNote.Off
-						}
-					}
+					finishNote(activeNote)
 					delete(activeNotes, int(key))
 				}
 			}
@@ -98,21 +92,29 @@ func (mp *MidiProcessor) LoadMIDI() ([]*VirtualNote, int, error) {
 
 		// Handle any remaining active notes at end of track
 		for _, activeNote := range activeNotes {
-			activeNote.Off = int(float64(currentTime)/float64(clocksPerRow) + 0.5)
-			activeNote.Length = activeNote.Off - activeNote.Start
-			if activeNote.Length > 0 {
-				virtualNotes = append(virtualNotes, activeNote)
-				if activeNote.Off > maxRow {
-					maxRow = activeNote.Off
-				}
+			finishNote(activeNote)
+		}
+
+		// Notes shorter than a row round to zero length
+		trackNotes = mp.applyGracePolicy(trackNotes, graceStats)
+		for _, note := range trackNotes {
+			virtualNotes = append(virtualNotes, note)
+			if note.Off > maxRow {
+				maxRow = note.Off
 			}
 		}
 	}
 
+	mp.reportGrace(graceStats)
 	fmt.Printf("max_row:%d\n", maxRow)
 	return virtualNotes, maxRow, nil
 }
 
+// DroppedNotes returns the sub-row notes the grace policy discarded
+func (mp *MidiProcessor) DroppedNotes() []*VirtualNote {
+	return mp.dropped
+}
+
 // GetCurrentTimestamp returns current timestamp in the format used by original
 func GetCurrentTimestamp() string {
 	now := time.Now()
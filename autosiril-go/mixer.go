@@ -1,23 +1,22 @@
 package main
 
 import (
-	"fmt"
-	"strconv"
 	"strings"
 )
 
 // ChannelMixer handles multi-channel mixing to AY channels
 type ChannelMixer struct {
-	config *AutosirilConfig
+	config    *AutosirilConfig
+	ornaments *OrnamentGenerator // for ornaments folding multiplexed voices and resuming stolen melody
 }
 
-func NewChannelMixer(config *AutosirilConfig) *ChannelMixer {
-	return &ChannelMixer{config: config}
+func NewChannelMixer(config *AutosirilConfig, ornaments *OrnamentGenerator) *ChannelMixer {
+	return &ChannelMixer{config: config, ornaments: ornaments}
 }
 
 // MixChannels mixes virtual channels into 3 AY sound chip channels
-func (cm *ChannelMixer) MixChannels(timelines [][]*TimelineNote, channelSettings [][]ChannelSettings) [][]*VortexNote {
-	fmt.Println("--- mixing channels ---")
+func (cm *ChannelMixer) MixChannels(timelines [][]*TimelineNote, channelSettings [][]ChannelSettings, routed []RoutedEcho) [][]*VortexNote {
+	cm.config.logf("--- mixing channels ---\n")
 	
 	// Create 3 AY channels
 	ayChannels := make([][]*VortexNote, 3)
@@ -40,6 +39,14 @@ func (cm *ChannelMixer) MixChannels(timelines [][]*TimelineNote, channelSettings
 	}
 	
 	// Mix virtual channels into AY channels based on channel settings
+	type deferredEntry struct {
+		ayIdx    int
+		timeline []*TimelineNote
+		setting  ChannelSettings
+	}
+	var steals, muxes []deferredEntry
+	owners := make(map[*VortexNote]int)        // entry of every mixed cell
+	resumes := make(map[*VortexNote]*VortexNote) // held note a continue plays again
 	virtualChannelIndex := 0
 	for ayIdx, ayChannelSettings := range channelSettings {
 		if ayIdx >= 3 {
@@ -49,15 +56,35 @@ func (cm *ChannelMixer) MixChannels(timelines [][]*TimelineNote, channelSettings
 		for _, setting := range ayChannelSettings {
 			if virtualChannelIndex < len(timelines) {
 				timeline := timelines[virtualChannelIndex]
+				if setting.Pool != "" {
+					// Pooled notes are allocated to idle channels afterwards
+					virtualChannelIndex++
+					continue
+				}
+				if setting.Steal {
+					// Stealing drums go over the finished melody
+					steals = append(steals, deferredEntry{ayIdx, timeline, setting})
+					virtualChannelIndex++
+					continue
+				}
+				if setting.Mux != "" {
+					// Multiplexed voices share the channel with what is mixed before them
+					muxes = append(muxes, deferredEntry{ayIdx, timeline, setting})
+					virtualChannelIndex++
+					continue
+				}
 				
+				var held melodyState
 				for pos, timelineNote := range timeline {
 					if pos < len(ayChannels[ayIdx]) && timelineNote.Type != "." {
-						// Convert timeline note to vortex note
-						vortexNote := NewVortexNote(timelineNote)
-						vortexNote.InstrumentKind = setting.InstrumentType
-						
-						// Apply sample and ornament assignments based on instrument type
-						cm.applyInstrumentSettings(vortexNote, &setting)
+						vortexNote := cm.entryNote(timelineNote, &setting)
+						held.update(pos, vortexNote)
+						owners[vortexNote] = virtualChannelIndex
+						if vortexNote.Type == "c" && held.note != nil {
+							resumed := *held.note
+							resumed.Ornament = held.ornament
+							resumes[vortexNote] = &resumed
+						}
 						
 						// Priority mixing - only replace if slot is empty or lower priority
 						if ayChannels[ayIdx][pos].Type == "." || timelineNote.Volume > ayChannels[ayIdx][pos].Volume {
@@ -69,10 +96,100 @@ func (cm *ChannelMixer) MixChannels(timelines [][]*TimelineNote, channelSettings
 			}
 		}
 	}
+
+	for _, channel := range ayChannels {
+		resumeHeldNotes(channel, owners, resumes)
+	}
+	for _, mux := range muxes {
+		cm.multiplex(ayChannels[mux.ayIdx], mux.timeline, &mux.setting, mux.ayIdx)
+	}
+	for _, echo := range routed {
+		cm.mixRoutedEcho(ayChannels[echo.Target], echo)
+	}
+	for _, steal := range steals {
+		cm.stealDrums(ayChannels[steal.ayIdx], steal.timeline, &steal.setting, steal.ayIdx)
+	}
 	
 	return ayChannels
 }
 
+// resumeHeldNotes plays a held note again on its first continue row after
+// another entry sounded on the channel, which took the channel's tone
+func resumeHeldNotes(channel []*VortexNote, owners map[*VortexNote]int, resumes map[*VortexNote]*VortexNote) {
+	sounding := -1 // entry of the note the channel plays
+	for pos, cell := range channel {
+		switch cell.Type {
+		case "s", "o":
+			sounding = -1
+			if owner, ok := owners[cell]; ok {
+				sounding = owner
+			}
+		case "r":
+			sounding = -1
+		case "c":
+			if owner, ok := owners[cell]; ok && owner != sounding && resumes[cell] != nil {
+				channel[pos] = resumes[cell]
+				sounding = owner
+			}
+		}
+	}
+}
+
+// entryNote converts a timeline note of an entry to the note written to the
+// AY channel
+func (cm *ChannelMixer) entryNote(timelineNote *TimelineNote, setting *ChannelSettings) *VortexNote {
+	vortexNote := NewVortexNote(timelineNote)
+	vortexNote.InstrumentKind = setting.InstrumentType
+	
+	// Apply sample and ornament assignments based on instrument type
+	cm.applyInstrumentSettings(vortexNote, setting)
+	if timelineNote.Echo {
+		cm.applyEchoSettings(vortexNote, setting)
+	}
+
+	// Continues only hold the channel unless the entry retriggers them
+	if vortexNote.Type == "c" && strings.Contains(setting.Modifiers, "r") {
+		vortexNote.Type = "s"
+	}
+	return vortexNote
+}
+
+// mixRoutedEcho places ping-pong echo taps where the target AY channel is
+// idle: a tap starts on an empty or release row and keeps its following rows
+// only while they stay empty
+func (cm *ChannelMixer) mixRoutedEcho(channel []*VortexNote, echo RoutedEcho) {
+	placed, total := 0, 0
+	placing := false
+	for pos, timelineNote := range echo.Timeline {
+		if pos >= len(channel) || timelineNote.Type == "." {
+			continue
+		}
+		if timelineNote.Type == "s" {
+			total++
+			placing = channel[pos].Type == "." || channel[pos].Type == "r"
+			if placing {
+				placed++
+			}
+		} else if channel[pos].Type != "." {
+			placing = false
+		}
+		if !placing {
+			continue
+		}
+
+		vortexNote := NewVortexNote(timelineNote)
+		vortexNote.InstrumentKind = echo.Setting.InstrumentType
+		cm.applyInstrumentSettings(vortexNote, &echo.Setting)
+		cm.applyEchoSettings(vortexNote, &echo.Setting)
+		channel[pos] = vortexNote
+		if timelineNote.Type == "r" {
+			placing = false
+		}
+	}
+	cm.config.logf("ping-pong echo of track %d: %d of %d taps placed on channel %c\n",
+		echo.Setting.MIDIChannel, placed, total, 'A'+echo.Target)
+}
+
 func (cm *ChannelMixer) applyInstrumentSettings(note *VortexNote, setting *ChannelSettings) {
 	switch setting.InstrumentType {
 	case "d": // Drums
@@ -88,30 +205,56 @@ func (cm *ChannelMixer) applyInstrumentSettings(note *VortexNote, setting *Chann
 			}
 			
 		}
+		if ornNum, ok := settingsOrnament(note.Settings); ok {
+			note.Ornament = ornNum
+		}
 		note.Envelope = 15
 		
 	case "m": // Monophonic
 		note.Sample = setting.Sample
-		note.Ornament = setting.Ornament
+		note.Ornament = cm.noteOrnament(note, setting)
 		note.Envelope = 15
 		
 	case "p": // Polyphonic
 		note.Sample = setting.Sample
-		// Check if ornament was generated from chord data
-		if strings.HasPrefix(note.Settings, "ornament:") {
-			if ornNum, err := strconv.Atoi(strings.TrimPrefix(note.Settings, "ornament:")); err == nil {
-				note.Ornament = ornNum
-			} else {
-				note.Ornament = setting.Ornament
-			}
-		} else {
-			note.Ornament = setting.Ornament
-		}
+		note.Ornament = cm.noteOrnament(note, setting)
 		note.Envelope = 15
 		
 	case "e": // Envelope
 		note.Sample = setting.Sample
-		note.Ornament = setting.Ornament
-		// Envelope form already set in VortexNote constructor
+		note.Ornament = cm.noteOrnament(note, setting)
+		note.EnvPriority = setting.EnvPriority
+		// Envelope form already set in VortexNote constructor from Ruby's tables
+		if cm.config.Tuning != nil {
+			note.setEnvelope(cm.config.Tuning.Offsets, cm.config.Tuning.Forms)
+		}
+	}
+}
+
+// applyEchoSettings gives echoes the entry's echo sample and ornament. Echoes
+// on envelope channels play without the envelope, which would restore full
+// volume, on sample 2 like Ruby.
+func (cm *ChannelMixer) applyEchoSettings(note *VortexNote, setting *ChannelSettings) {
+	if setting.InstrumentType == "e" {
+		note.Envelope = 15
+		note.Sample = 2
+	}
+	if setting.EchoSample > 0 {
+		note.Sample = setting.EchoSample
+	}
+	if setting.EchoOrnament >= 0 {
+		note.Ornament = setting.EchoOrnament
+		if note.Type == "o" {
+			note.Type = "c" // the echo keeps its own ornament
+		}
+	}
+}
+
+// noteOrnament returns the ornament generated for the note (chord or grace
+// ornament), falling back to the channel's ornament from the mapping
+func (cm *ChannelMixer) noteOrnament(note *VortexNote, setting *ChannelSettings) int {
+	if ornNum, ok := settingsOrnament(note.Settings); ok {
+		return ornNum
 	}
+	return setting.Ornament
 }
\ No newline at end of file
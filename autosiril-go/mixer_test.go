@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// mixMapping flattens and mixes notes with a mapping and returns the first
+// AY channel
+func mixMapping(t *testing.T, mapping string, notes []*VirtualNote, rows int) []*VortexNote {
+	t.Helper()
+	channels, err := parseChannelMapping(mapping)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	config := newTestConfig(t)
+	timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, rows, channels)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	return NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, nil)[0]
+}
+
+// cellTypes concatenates the cell types of a mixed channel
+func cellTypes(cells []*VortexNote) string {
+	var types strings.Builder
+	for _, cell := range cells {
+		types.WriteString(cell.Type)
+	}
+	return types.String()
+}
+
+func TestMixRetrigger(t *testing.T) {
+	notes := []*VirtualNote{
+		{Note: 48, Volume: 15, Start: 0, Off: 4, Channel: 1},
+	}
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"1p", "sccr"},
+		{"1pr", "sssr"},
+	}
+	for _, tt := range tests {
+		if got := cellTypes(mixMapping(t, tt.mapping, notes, 4)[:4]); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestMixResumesHeldChord(t *testing.T) {
+	chord := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 3},
+		{Note: 64, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 3},
+	}
+	drum := &VirtualNote{Note: 36, Volume: 15, Start: 2, Off: 3, Length: 1, Channel: 1}
+	tests := []struct {
+		name  string
+		notes []*VirtualNote
+		want  string
+	}{
+		{"held alone", chord, "0:s60@1 1:c60@1 2:c60@1 3:c60@1 4:c60@1 5:r60"},
+		{"drum in between", append([]*VirtualNote{drum}, chord...), "0:s60@1 1:c60@1 2:s60 3:s60@1 4:c60@1 5:r60"},
+	}
+	for _, tt := range tests {
+		if got := renderMix(mixMapping(t, "1du-3pu", tt.notes, 8)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Multiplexing modes selected with "{mux=...}"
+const (
+	MuxRows   = "rows"   // alternate the voices on successive rows (default)
+	MuxFrames = "frames" // fold both notes into an ornament alternating on frames
+)
+
+// muxSegment is a run of rows where the same two notes collide
+type muxSegment struct {
+	start, end int
+	occupant   *VortexNote
+	voice      *VortexNote
+	ornament   int // fold ornament, with MuxFrames
+}
+
+// multiplex mixes a "{mux}" entry into an AY channel. Where the channel is
+// free the entry's notes play as usual; where they collide with the note
+// already there, the two alternate by rows with the entry's duty, or are
+// folded into one note with an alternating ornament. One of them is only
+// dropped when neither fits the collision.
+func (cm *ChannelMixer) multiplex(channel []*VortexNote, timeline []*TimelineNote, setting *ChannelSettings, ayIdx int) {
+	layer := make([]*VortexNote, len(channel))
+	copy(layer, channel)
+
+	// Follow both voices row by row
+	occupant := make([]melodyState, len(channel))
+	voice := make([]melodyState, len(channel))
+	cells := make([]*VortexNote, len(channel))
+	var occ, mux melodyState
+	for pos := range channel {
+		occ.update(pos, layer[pos])
+		cells[pos] = NewVortexNote(NewTimelineNote(0, 0, "."))
+		if pos < len(timeline) && timeline[pos].Type != "." {
+			cells[pos] = cm.entryNote(timeline[pos], setting)
+		}
+		mux.update(pos, cells[pos])
+		occupant[pos], voice[pos] = occ, mux
+	}
+
+	dutyOcc, dutyVoice := muxDuty(setting)
+	playing := "" // "occupant", "voice" or "fold"
+	var seg *muxSegment
+	var mode string
+	collisions := 0
+
+	// play puts a voice on the channel, retriggering it when another one
+	// played on the previous row
+	play := func(pos int, which string, state melodyState, cell *VortexNote) {
+		if cell.Type == "s" {
+			channel[pos] = cell
+		} else if playing != which {
+			resumed := *state.note
+			resumed.Ornament = state.ornament
+			resumed.Type = "s"
+			channel[pos] = &resumed
+		} else {
+			channel[pos] = cell
+		}
+		playing = which
+	}
+
+	for pos := range channel {
+		occOn, voiceOn := occupant[pos].note != nil, voice[pos].note != nil
+		switch {
+		case occOn && voiceOn:
+			if seg == nil || pos >= seg.end {
+				seg = cm.muxSegmentAt(pos, occupant, voice)
+				mode = cm.chooseMux(seg, setting, ayIdx)
+				collisions++
+				if mode == MuxFrames {
+					channel[pos] = foldedNote(seg)
+					playing = "fold"
+					continue
+				}
+			}
+			turn := "voice"
+			switch mode {
+			case MuxRows:
+				if (pos-seg.start)%(dutyOcc+dutyVoice) < dutyOcc {
+					turn = "occupant"
+				}
+			case MuxFrames:
+				channel[pos] = NewVortexNote(NewTimelineNote(0, 0, "."))
+				continue
+			case "occupant":
+				turn = "occupant"
+			}
+			if turn == "occupant" {
+				play(pos, "occupant", occupant[pos], layer[pos])
+			} else {
+				play(pos, "voice", voice[pos], cells[pos])
+			}
+		case voiceOn:
+			play(pos, "voice", voice[pos], cells[pos])
+		case occOn:
+			play(pos, "occupant", occupant[pos], layer[pos])
+		default:
+			// Release whatever the channel was playing
+			if playing != "occupant" && playing != "" && (cells[pos].Type == "r" || layer[pos].Type == "r") {
+				channel[pos] = NewVortexNote(NewTimelineNote(0, 0, "r"))
+			}
+			playing = ""
+		}
+	}
+	cm.config.logf("mux track %d on channel %c: %d collisions\n", setting.MIDIChannel, 'A'+ayIdx, collisions)
+}
+
+// muxSegmentAt returns the collision starting at pos: the rows until either
+// voice stops or changes its note
+func (cm *ChannelMixer) muxSegmentAt(pos int, occupant, voice []melodyState) *muxSegment {
+	seg := &muxSegment{start: pos, occupant: occupant[pos].note, voice: voice[pos].note}
+	end := pos + 1
+	for end < len(occupant) && occupant[end].note == seg.occupant && voice[end].note == seg.voice {
+		end++
+	}
+	seg.end = end
+	return seg
+}
+
+// chooseMux picks how to share one collision and logs the choice. Rows
+// alternate when the collision lasts a full duty cycle, otherwise (or with
+// MuxFrames) the notes are folded into an ornament if possible. The louder
+// voice is kept when neither works.
+func (cm *ChannelMixer) chooseMux(seg *muxSegment, setting *ChannelSettings, ayIdx int) string {
+	rows := seg.end - seg.start
+	dutyOcc, dutyVoice := muxDuty(setting)
+	cycle := dutyOcc + dutyVoice
+
+	mode, why := MuxRows, fmt.Sprintf("alternating rows %d:%d", dutyOcc, dutyVoice)
+	if seg.occupant.InstrumentKind == "d" || seg.voice.InstrumentKind == "d" {
+		// A drum hit plays out and the other voice resumes after it
+		mode, why = "occupant", fmt.Sprintf("the drum hit %s keeps the channel", seg.occupant.String())
+		if seg.voice.InstrumentKind == "d" {
+			mode, why = "voice", fmt.Sprintf("the drum hit %s keeps the channel", seg.voice.String())
+		}
+	} else if setting.Mux == MuxFrames || rows < cycle {
+		values, err := foldValues(seg, setting, cm.config.MaxOffset)
+		if err == nil {
+			if id, ok := cm.ornaments.AddOrnament(ornamentKey(values, 0)); ok {
+				seg.ornament = id
+				mode, why = MuxFrames, fmt.Sprintf("folded into ornament %d", id)
+			} else {
+				err = fmt.Errorf("no free ornament slot")
+			}
+		}
+		if mode == MuxRows && rows < cycle {
+			mode = "occupant"
+			kept, lost := seg.occupant, seg.voice
+			if seg.voice.Volume > seg.occupant.Volume {
+				mode, kept, lost = "voice", seg.voice, seg.occupant
+			}
+			why = fmt.Sprintf("kept %s, sacrificed %s (%d rows are shorter than the duty cycle and %v)",
+				kept.String(), lost.String(), rows, err)
+		}
+	}
+	cm.config.logf("mux track %d on channel %c rows %d-%d: %s with %s, %s\n",
+		setting.MIDIChannel, 'A'+ayIdx, seg.start, seg.end-1, seg.voice.String(), seg.occupant.String(), why)
+	return mode
+}
+
+// foldValues returns the ornament playing both notes of a collision from
+// the lower one, each for its duty in frames
+func foldValues(seg *muxSegment, setting *ChannelSettings, maxOffset int) ([]int, error) {
+	if seg.occupant.InstrumentKind == "e" || seg.voice.InstrumentKind == "e" {
+		return nil, fmt.Errorf("envelope notes cannot be folded")
+	}
+	if seg.occupant.Sample != seg.voice.Sample {
+		return nil, fmt.Errorf("the notes use different samples")
+	}
+	if seg.occupant.Ornament != 0 || seg.voice.Ornament != 0 {
+		return nil, fmt.Errorf("the notes already have ornaments")
+	}
+	interval := seg.voice.Note - seg.occupant.Note
+	if abs(interval) > maxOffset {
+		return nil, fmt.Errorf("the interval %d exceeds MAX_OFFSET", interval)
+	}
+
+	occOffset, voiceOffset := 0, interval
+	if interval < 0 {
+		occOffset, voiceOffset = -interval, 0
+	}
+	dutyOcc, dutyVoice := muxDuty(setting)
+	var values []int
+	for i := 0; i < dutyOcc; i++ {
+		values = append(values, occOffset)
+	}
+	for i := 0; i < dutyVoice; i++ {
+		values = append(values, voiceOffset)
+	}
+	return values, nil
+}
+
+// muxDuty returns the rows (or frames) of the occupant and of the entry's voice
+func muxDuty(setting *ChannelSettings) (int, int) {
+	if setting.MuxDuty[0] == 0 {
+		return 1, 1
+	}
+	return setting.MuxDuty[0], setting.MuxDuty[1]
+}
+
+// parseMuxOption applies the mux and duty options
+func parseMuxOption(setting *ChannelSettings, name, value string) error {
+	if name == "mux" {
+		switch value {
+		case "", MuxRows:
+			setting.Mux = MuxRows
+		case MuxFrames:
+			setting.Mux = MuxFrames
+		default:
+			return fmt.Errorf("unknown mux mode '%s' (expected rows or frames)", value)
+		}
+		return nil
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("duty expects two counts like 2.1, got '%s'", value)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > 16 {
+			return fmt.Errorf("duty count '%s' out of range 1-16", part)
+		}
+		setting.MuxDuty[i] = n
+	}
+	return nil
+}
+
+// foldedNote returns the lower note of a collision carrying the fold ornament
+func foldedNote(seg *muxSegment) *VortexNote {
+	base := seg.occupant
+	if seg.voice.Note < base.Note {
+		base = seg.voice
+	}
+	folded := *base
+	folded.Type = "s"
+	folded.Ornament = seg.ornament
+	folded.Volume = max(seg.occupant.Volume, seg.voice.Volume)
+	folded.Sources = append(append([]*VirtualNote{}, seg.occupant.Sources...), seg.voice.Sources...)
+	return &folded
+}
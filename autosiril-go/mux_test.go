@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// renderMix renders the non-empty cells of a mixed channel as row:typeNote,
+// with the ornament when one is set
+func renderMix(cells []*VortexNote) string {
+	var parts []string
+	for row, cell := range cells {
+		if cell.Type == "." {
+			continue
+		}
+		part := fmt.Sprintf("%d:%s%d", row, cell.Type, cell.Note)
+		if cell.Ornament != 0 {
+			part += fmt.Sprintf("@%d", cell.Ornament)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestMultiplex(t *testing.T) {
+	tests := []struct {
+		mapping string
+		notes   []*VirtualNote
+		want    string
+	}{
+		{"1m-2m{mux}", []*VirtualNote{
+			{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 1},
+			{Note: 64, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 2},
+		}, "0:s60 1:s64 2:s60 3:s64 4:s60 5:r60"},
+		{"1m-2m{mux,duty=2.1}", []*VirtualNote{
+			{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 1},
+			{Note: 64, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 2},
+		}, "0:s60 2:s64 3:s60 5:r60"},
+		{"1m-2m{mux=frames}", []*VirtualNote{
+			{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 1},
+			{Note: 64, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 2},
+		}, "0:s60@1 5:r0"},
+		{"1m-2m{mux,duty=2.2}", []*VirtualNote{
+			{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 1},
+			{Note: 64, Volume: 15, Start: 2, Off: 4, Length: 2, Channel: 2},
+		}, "0:s60 2:s60@1 3:s60 5:r60"},
+		{"1m-2m{mux}", []*VirtualNote{
+			{Note: 60, Volume: 15, Start: 0, Off: 2, Length: 2, Channel: 1},
+			{Note: 64, Volume: 15, Start: 3, Off: 6, Length: 3, Channel: 2},
+		}, "0:s60 1:r60 3:s64 5:r0"},
+		{"1m-2m[3]{mux,duty=2.2}", []*VirtualNote{ // different samples cannot fold
+			{Note: 60, Volume: 15, Start: 0, Off: 6, Length: 6, Channel: 1},
+			{Note: 64, Volume: 10, Start: 2, Off: 4, Length: 2, Channel: 2},
+		}, "0:s60 5:r60"},
+	}
+	for _, tt := range tests {
+		if got := renderMix(mixMapping(t, tt.mapping, tt.notes, 8)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestFoldValues(t *testing.T) {
+	note := func(kind string, note, sample, ornament int) *VortexNote {
+		return &VortexNote{InstrumentKind: kind, Note: note, Sample: sample, Ornament: ornament}
+	}
+	tests := []struct {
+		occupant, voice *VortexNote
+		duty            [2]int
+		want            string
+	}{
+		{note("m", 60, 2, 0), note("m", 64, 2, 0), [2]int{}, "[0 4]"},
+		{note("m", 64, 2, 0), note("m", 60, 2, 0), [2]int{2, 1}, "[4 4 0]"},
+		{note("m", 60, 2, 0), note("e", 64, 2, 0), [2]int{}, "envelope notes cannot be folded"},
+		{note("m", 60, 2, 0), note("m", 64, 3, 0), [2]int{}, "the notes use different samples"},
+		{note("p", 60, 2, 1), note("m", 64, 2, 0), [2]int{}, "the notes already have ornaments"},
+		{note("m", 60, 2, 0), note("m", 90, 2, 0), [2]int{}, "the interval 30 exceeds MAX_OFFSET"},
+	}
+	for _, tt := range tests {
+		seg := &muxSegment{occupant: tt.occupant, voice: tt.voice}
+		values, err := foldValues(seg, &ChannelSettings{MuxDuty: tt.duty}, 12)
+		got := fmt.Sprint(values)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("fold %d with %d duty %v: got %s, want %s", tt.occupant.Note, tt.voice.Note, tt.duty, got, tt.want)
+		}
+	}
+}
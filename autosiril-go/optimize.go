@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxCandidates limits the mappings converted in each search stage
+const DefaultMaxCandidates = 2000
+
+// MappingScore is the result of converting one candidate mapping
+type MappingScore struct {
+	Mapping  string
+	Lost     int     // lost source notes
+	LostRows int     // rows of the lost notes
+	Score    float64 // lost rows weighted per track
+	err      error
+}
+
+// MappingOptimizer converts candidate mappings of a set of entries and ranks
+// them by the note rows they lose
+type MappingOptimizer struct {
+	config       *AutosirilConfig
+	quiet        *AutosirilConfig // config of the candidate conversions, without progress output
+	virtualNotes []*VirtualNote
+	droppedNotes []*VirtualNote
+	maxRow       int
+}
+
+func NewMappingOptimizer(config *AutosirilConfig, virtualNotes, droppedNotes []*VirtualNote, maxRow int) *MappingOptimizer {
+	quiet := *config
+	quiet.Log = io.Discard
+	return &MappingOptimizer{config: config, quiet: &quiet, virtualNotes: virtualNotes, droppedNotes: droppedNotes, maxRow: maxRow}
+}
+
+// RunOptimize implements "autosiril optimize INPUT_FILE ENTRIES [PER_BEAT ...]"
+func RunOptimize(args []string) error {
+	config, err := NewAutosirilConfig(args)
+	if err != nil {
+		return err
+	}
+	entries := splitMappingEntries(config.ChannelMapping)
+	for _, entry := range entries {
+		if _, err := parseChannelMapping(entry); err != nil {
+			return fmt.Errorf("entry '%s': %v", entry, err)
+		}
+	}
+	if len(entries) < 2 {
+		return fmt.Errorf("optimize needs at least two entries, like \"1d-2me-3p-4m\"")
+	}
+
+	midiProcessor := NewMidiProcessor(config)
+	virtualNotes, maxRow, err := midiProcessor.LoadMIDI()
+	if err != nil {
+		return err
+	}
+	channelSettings, err := parseChannelMapping(strings.Join(entries, "-"))
+	if err != nil {
+		return err
+	}
+	keyProcessor := NewKeyProcessor(config)
+	keyProcessor.TransposeNotes(virtualNotes, keyProcessor.DetectKey(virtualNotes), channelSettings)
+	config.Tuning = NewEnvelopeTuning(config)
+
+	optimizer := NewMappingOptimizer(config, virtualNotes, midiProcessor.DroppedNotes(), maxRow)
+	best, err := optimizer.Optimize(entries)
+	if err != nil {
+		return err
+	}
+	for i, result := range best {
+		fmt.Printf("%d. \"%s\"  %d lost note rows (weighted %.0f), %d notes lost\n",
+			i+1, result.Mapping, result.LostRows, result.Score, result.Lost)
+	}
+	return nil
+}
+
+// Optimize searches in two stages: every assignment of the entries to the
+// AY channels (keeping their order), then every order within the channels
+// of the best assignments. The conversions of a stage run concurrently with
+// the pipeline's progress output discarded. The "+" mix option is left as
+// given, since the mixer doesn't read it.
+func (mo *MappingOptimizer) Optimize(entries []string) ([]MappingScore, error) {
+	fmt.Println("--- optimizing mapping ---")
+	keep := mo.config.Suggestions
+	limit := max(mo.maxCandidates()/keep, 1)
+
+	assignments := mo.assignments(entries)
+	start := time.Now()
+	stage1 := mo.score(assignments)
+	fmt.Printf("%d channel assignments converted in %.1fs\n", len(assignments), time.Since(start).Seconds())
+
+	orderings := mo.expand(stage1[:min(keep, len(stage1))], func(mapping string) []string {
+		return mo.orderings(mapping, limit)
+	})
+	start = time.Now()
+	stage2 := mo.score(orderings)
+	fmt.Printf("%d orderings of the best %d converted in %.1fs\n", len(orderings), min(keep, len(stage1)), time.Since(start).Seconds())
+
+	if len(stage2) == 0 {
+		return nil, fmt.Errorf("no candidate mapping converted")
+	}
+	return stage2[:min(keep, len(stage2))], nil
+}
+
+// expand returns the distinct variants of the given results
+func (mo *MappingOptimizer) expand(results []MappingScore, variants func(mapping string) []string) []string {
+	var mappings []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		for _, mapping := range variants(result.Mapping) {
+			if !seen[mapping] {
+				seen[mapping] = true
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+	return mappings
+}
+
+// score converts the mappings on all CPUs and returns the valid ones, best first
+func (mo *MappingOptimizer) score(mappings []string) []MappingScore {
+	results := make([]MappingScore, len(mappings))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = mo.convert(mappings[i])
+			}
+		}()
+	}
+	for i := range mappings {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var valid []MappingScore
+	for _, result := range results {
+		if result.err == nil {
+			valid = append(valid, result)
+		}
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		if valid[i].Score != valid[j].Score {
+			return valid[i].Score < valid[j].Score
+		}
+		return valid[i].Mapping < valid[j].Mapping
+	})
+	return valid
+}
+
+// convert runs the real pipeline for one mapping and scores its losses
+func (mo *MappingOptimizer) convert(mapping string) MappingScore {
+	result := MappingScore{Mapping: mapping}
+	channelSettings, err := parseChannelMapping(mapping)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	_, _, lostNotes, err := convertMapping(mo.quiet, channelSettings, mo.virtualNotes, mo.droppedNotes, mo.maxRow)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	for _, lost := range lostNotes.Lost {
+		if lost.Stage == LostGrace {
+			continue // the same for every mapping of the entries
+		}
+		rows := max(lost.Rows, 1)
+		weight, ok := mo.config.TrackWeights[lost.Track]
+		if !ok {
+			weight = 1
+		}
+		result.Lost++
+		result.LostRows += rows
+		result.Score += float64(rows) * weight
+	}
+	return result
+}
+
+func (mo *MappingOptimizer) maxCandidates() int {
+	if mo.config.MaxCandidates > 0 {
+		return mo.config.MaxCandidates
+	}
+	return DefaultMaxCandidates
+}
+
+// assignments returns the mappings placing the entries on one to three AY
+// channels in their given order. Mappings differing only by the order of
+// the AY channels are generated once.
+func (mo *MappingOptimizer) assignments(entries []string) []string {
+	var mappings []string
+	groups := make([]int, len(entries))
+	var place func(i, used int)
+	place = func(i, used int) {
+		if len(mappings) == mo.maxCandidates() {
+			return
+		}
+		if i == len(entries) {
+			channels := make([][]string, used)
+			for e, group := range groups {
+				channels[group] = append(channels[group], entries[e])
+			}
+			mappings = append(mappings, joinMapping(channels))
+			return
+		}
+		for group := 0; group <= used && group < 3; group++ {
+			groups[i] = group
+			place(i+1, max(used, group+1))
+		}
+	}
+	place(0, 0)
+	return mappings
+}
+
+// orderings returns up to limit mappings with the entries of each AY channel
+// of mapping in every order
+func (mo *MappingOptimizer) orderings(mapping string, limit int) []string {
+	var channels [][]string
+	for _, channel := range strings.Split(mapping, ",") {
+		channels = append(channels, splitMappingEntries(channel))
+	}
+
+	var mappings []string
+	current := make([][]string, len(channels))
+	var order func(ch int)
+	order = func(ch int) {
+		if len(mappings) >= limit {
+			return
+		}
+		if ch == len(channels) {
+			mappings = append(mappings, joinMapping(current))
+			return
+		}
+		for _, perm := range permutations(channels[ch]) {
+			current[ch] = perm
+			order(ch + 1)
+		}
+	}
+	order(0)
+	return mappings
+}
+
+func permutations(entries []string) [][]string {
+	if len(entries) <= 1 {
+		return [][]string{entries}
+	}
+	var result [][]string
+	for i, first := range entries {
+		rest := append(append([]string{}, entries[:i]...), entries[i+1:]...)
+		for _, perm := range permutations(rest) {
+			result = append(result, append([]string{first}, perm...))
+		}
+	}
+	return result
+}
+
+func joinMapping(channels [][]string) string {
+	parts := make([]string, len(channels))
+	for i, channel := range channels {
+		parts[i] = strings.Join(channel, "-")
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitMappingEntries splits a mapping at the '-' and ',' between entries,
+// keeping brackets and options (which may contain '-') together
+func splitMappingEntries(mapping string) []string {
+	var entries []string
+	depth, start := 0, 0
+	for i, c := range mapping {
+		switch c {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '-', ',':
+			if depth == 0 {
+				if entry := strings.TrimSpace(mapping[start:i]); entry != "" {
+					entries = append(entries, entry)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if entry := strings.TrimSpace(mapping[start:]); entry != "" {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// parseTrackWeights parses "--weights=2:3,4:1.5"
+func parseTrackWeights(value string) (map[int]float64, error) {
+	weights := make(map[int]float64)
+	for _, part := range strings.Split(value, ",") {
+		track, weight, ok := strings.Cut(part, ":")
+		t, err1 := strconv.Atoi(track)
+		w, err2 := strconv.ParseFloat(weight, 64)
+		if !ok || err1 != nil || err2 != nil || w < 0 {
+			return nil, fmt.Errorf("weights expect TRACK:WEIGHT pairs like 2:3,4:1.5, got '%s'", part)
+		}
+		weights[t] = w
+	}
+	return weights, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMappingEntries(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    []string
+	}{
+		{"1d-2me-3p-4m", []string{"1d", "2me", "3p", "4m"}},
+		{"1d,2me-3p", []string{"1d", "2me", "3p"}},
+		{"1m{attack=-12.0}-2p[3]{arp=up}+", []string{"1m{attack=-12.0}", "2p[3]{arp=up}+"}},
+		{" 1m - 2m ", []string{"1m", "2m"}},
+	}
+	for _, tt := range tests {
+		if got := splitMappingEntries(tt.mapping); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMappingEntries(%q) = %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestOptimizerCandidates(t *testing.T) {
+	mo := NewMappingOptimizer(newTestConfig(t), nil, nil, 0)
+	tests := []struct {
+		entries []string
+		count   int
+		first   string
+		last    string
+	}{
+		{[]string{"1d", "2m"}, 2, "1d-2m", "1d,2m"},
+		{[]string{"1d", "2m", "3p"}, 5, "1d-2m-3p", "1d,2m,3p"},
+		{[]string{"1d", "2m", "3p", "4m"}, 14, "1d-2m-3p-4m", "1d,2m,3p-4m"},
+	}
+	for _, tt := range tests {
+		got := mo.assignments(tt.entries)
+		if len(got) != tt.count || got[0] != tt.first || got[len(got)-1] != tt.last {
+			t.Errorf("assignments(%q) = %q, want %d from %q to %q", tt.entries, got, tt.count, tt.first, tt.last)
+		}
+	}
+
+	orderings := mo.orderings("1d-2m,3p-4m", 10)
+	want := []string{"1d-2m,3p-4m", "1d-2m,4m-3p", "2m-1d,3p-4m", "2m-1d,4m-3p"}
+	if !reflect.DeepEqual(orderings, want) {
+		t.Errorf("orderings = %q, want %q", orderings, want)
+	}
+	if got := mo.orderings("1d-2m-3p", 4); len(got) != 4 {
+		t.Errorf("orderings limited to 4 returned %d", len(got))
+	}
+	limited := NewMappingOptimizer(newTestConfig(t, "--max-candidates=3"), nil, nil, 0)
+	if got := limited.assignments([]string{"1d", "2m", "3p"}); len(got) != 3 {
+		t.Errorf("assignments limited to 3 returned %d", len(got))
+	}
+}
+
+func TestParseTrackWeights(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[int]float64
+		err   bool
+	}{
+		{"2:3", map[int]float64{2: 3}, false},
+		{"2:3,4:1.5", map[int]float64{2: 3, 4: 1.5}, false},
+		{"2", nil, true},
+		{"x:1", nil, true},
+		{"2:-1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrackWeights(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConvertScore(t *testing.T) {
+	notes := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 1},
+		{Note: 64, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 2},
+	}
+	tests := []struct {
+		mapping string
+		weights string
+		lost    int
+		score   float64
+	}{
+		{"1mu,2mu", "1:1", 0, 0},
+		{"1mu-2mu", "1:1", 1, 4},
+		{"1mu-2mu", "2:2.5", 1, 10},
+	}
+	for _, tt := range tests {
+		mo := NewMappingOptimizer(newTestConfig(t, "--weights="+tt.weights), notes, nil, 8)
+		got := mo.convert(tt.mapping)
+		if got.err != nil || got.Lost != tt.lost || got.Score != tt.score {
+			t.Errorf("%q weights %s: lost %d score %v (%v), want %d %v", tt.mapping, tt.weights, got.Lost, got.Score, got.err, tt.lost, tt.score)
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	notes := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 1},
+		{Note: 64, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 2},
+		{Note: 67, Volume: 15, Start: 2, Off: 6, Length: 4, Channel: 3},
+	}
+	// Fewer candidates than results still converts one variant of each
+	config := newTestConfig(t, "--suggestions=5", "--max-candidates=2")
+	mo := NewMappingOptimizer(config, notes, nil, 8)
+	if mo.quiet.Log != io.Discard || config.Log != os.Stdout {
+		t.Fatalf("candidate conversions must log to io.Discard without changing the config")
+	}
+	best, err := mo.Optimize([]string{"1mu", "2mu", "3mu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(best) != 2 {
+		t.Errorf("got %d mappings from two candidates, want 2", len(best))
+	}
+
+	best, err = NewMappingOptimizer(newTestConfig(t), notes, nil, 8).Optimize([]string{"1mu", "2mu", "3mu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best[0].Lost != 0 || strings.Count(best[0].Mapping, ",") != 2 {
+		t.Errorf("best mapping %+v, want the tracks on separate channels", best[0])
+	}
+}
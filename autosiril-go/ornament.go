@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,6 +10,7 @@ import (
 type Ornament struct {
 	ID      int
 	Pattern []int
+	Loop    int // index of the pattern value the ornament loops back to
 }
 
 // OrnamentGenerator handles ornament creation from polyphonic chord data
@@ -18,6 +18,7 @@ type OrnamentGenerator struct {
 	config         *AutosirilConfig
 	ornaments      map[string]int // ornament pattern -> ornament number
 	ornamentCounter int
+	seeded         map[int]bool   // ids reserved by a persisted ornament table
 }
 
 func NewOrnamentGenerator(config *AutosirilConfig) *OrnamentGenerator {
@@ -25,6 +26,7 @@ func NewOrnamentGenerator(config *AutosirilConfig) *OrnamentGenerator {
 		config:          config,
 		ornaments:       make(map[string]int),
 		ornamentCounter: 1,
+		seeded:          make(map[int]bool),
 	}
 	
 	// Create "zero" ornament (Ruby's default ornament)
@@ -34,8 +36,10 @@ func NewOrnamentGenerator(config *AutosirilConfig) *OrnamentGenerator {
 	return og
 }
 
-// ProcessChordNotes generates ornaments from chord notes and returns base note and ornament number
-func (og *OrnamentGenerator) ProcessChordNotes(chordNotes []int) (int, int) {
+// ProcessChordNotes generates ornaments from chord notes and returns base note and ornament number.
+// The setting's Voicing canonicalizes inversions; prevBase is the base note of
+// the previous chord on the channel (-1 if none).
+func (og *OrnamentGenerator) ProcessChordNotes(chordNotes []int, setting *ChannelSettings, prevBase int) (int, int) {
 	if len(chordNotes) == 0 {
 		return 0, 0
 	}
@@ -45,6 +49,11 @@ func (og *OrnamentGenerator) ProcessChordNotes(chordNotes []int) (int, int) {
 		return chordNotes[0], 0
 	}
 	
+	if setting.Voicing != "" && len(pitchClasses(chordNotes)) > 1 {
+		baseNote, offsets := voiceChord(chordNotes, setting.Voicing, prevBase)
+		return baseNote, og.register(og.arpeggioString(offsets, setting))
+	}
+	
 	// Sort notes to find base note (minimum)
 	sort.Ints(chordNotes)
 	baseNote := chordNotes[0]
@@ -67,27 +76,145 @@ func (og *OrnamentGenerator) ProcessChordNotes(chordNotes []int) (int, int) {
 	}
 	
 	// Apply Ruby's squize_ornament logic
-	optimizedBaseNote, optimizedOffsets := og.squizeOrnament(baseNote, offsets)
+	optimizedBaseNote, optimizedOffsets := og.squizeOrnament(baseNote, offsets, setting.Outliers)
 	
 	// Create ornament string
-	ornamentString := og.createOrnamentString(optimizedOffsets)
+	ornamentString := og.arpeggioString(optimizedOffsets, setting)
 	
+	return optimizedBaseNote, og.register(ornamentString)
+}
+
+// keptChordTones returns the chord's source notes that ProcessChordNotes
+// keeps in the ornament, without the outliers it drops
+func (og *OrnamentGenerator) keptChordTones(sources []*VirtualNote, setting *ChannelSettings) []*VirtualNote {
+	if len(sources) <= 1 || setting.Voicing != "" {
+		return sources
+	}
+	base := sources[0].Note
+	for _, source := range sources {
+		base = min(base, source.Note)
+	}
+	var offsets []int
+	seen := make(map[int]bool)
+	for _, source := range sources {
+		if offset := source.Note - base; !seen[offset] {
+			seen[offset] = true
+			offsets = append(offsets, offset)
+		}
+	}
+	if len(offsets) <= 1 {
+		return sources
+	}
+	sort.Ints(offsets)
+	median := offsets[len(offsets)/2]
+
+	var kept []*VirtualNote
+	for _, source := range sources {
+		if len(og.fitOffsets([]int{source.Note - base}, median, setting.Outliers)) > 0 {
+			kept = append(kept, source)
+		}
+	}
+	return kept
+}
+
+// HeldChordOrnament returns the ornament for chord notes played relative to
+// an already sounding base note. It fails if the base note is not part of
+// the chord, because the chord could then only be played by retriggering.
+func (og *OrnamentGenerator) HeldChordOrnament(baseNote int, chordNotes []int, setting *ChannelSettings) (int, bool) {
+	var offsets []int
+	hasBase := false
+	for _, note := range chordNotes {
+		offset := note - baseNote
+		if offset == 0 {
+			hasBase = true
+		}
+		found := false
+		for _, existing := range offsets {
+			if existing == offset {
+				found = true
+				break
+			}
+		}
+		if !found {
+			offsets = append(offsets, offset)
+		}
+	}
+	if !hasBase {
+		return 0, false
+	}
+	sort.Ints(offsets)
+
+	// Fit outliers like squizeOrnament, but keep the held note itself
+	median := offsets[len(offsets)/2]
+	kept := og.fitOffsets(offsets, median, setting.Outliers)
+	hasBase = false
+	for _, offset := range kept {
+		if offset == 0 {
+			hasBase = true
+		}
+	}
+	if !hasBase {
+		kept = append(kept, 0)
+		sort.Ints(kept)
+	}
+	if len(kept) == 1 {
+		return 0, true
+	}
+	return og.register(og.arpeggioString(kept, setting)), true
+}
+
+// GraceOrnament returns an ornament that plays the grace offsets (relative
+// to the main note) once and then stays on the main note
+func (og *OrnamentGenerator) GraceOrnament(graceOffsets []int) int {
+	var values []int
+	for _, offset := range graceOffsets {
+		for i := 0; i < og.config.OrnRepeat; i++ {
+			values = append(values, offset)
+		}
+	}
+	values = append(values, 0)
+	return og.register(ornamentKey(values, len(values)-1))
+}
+
+// SequenceOrnament returns a looping ornament playing the offsets in order,
+// each for rate frames
+func (og *OrnamentGenerator) SequenceOrnament(offsets []int, rate int) int {
+	var values []int
+	for _, offset := range offsets {
+		for i := 0; i < rate; i++ {
+			values = append(values, offset)
+		}
+	}
+	return og.register(ornamentKey(values, 0))
+}
+
+// register returns the id of an ornament, allocating a new one if needed
+func (og *OrnamentGenerator) register(ornamentString string) int {
 	// Check if this ornament already exists
 	if ornNum, exists := og.ornaments[ornamentString]; exists {
-		return optimizedBaseNote, ornNum
+		return ornNum
 	}
 	
-	// Add new ornament
+	// Add new ornament, skipping ids of a seeded table
+	for og.seeded[og.ornamentCounter] {
+		og.ornamentCounter++
+	}
 	og.ornaments[ornamentString] = og.ornamentCounter
-	fmt.Printf("Created ornament %d: %s\n", og.ornamentCounter, ornamentString)
+	og.config.logf("Created ornament %d: %s\n", og.ornamentCounter, ornamentString)
 	ornNum := og.ornamentCounter
 	og.ornamentCounter++
 	
-	return optimizedBaseNote, ornNum
+	return ornNum
 }
 
+// Outlier policies for chord tones too far from the median, set with "{outliers=...}"
+const (
+	OutliersDrop = "drop" // remove them (Ruby behaviour, default)
+	OutliersFold = "fold" // move them by octaves toward the median
+)
+
 // squizeOrnament implements Ruby's ornament optimization logic
-func (og *OrnamentGenerator) squizeOrnament(baseNote int, offsets []int) (int, []int) {
+func (og *OrnamentGenerator) squizeOrnament(baseNote int, offsets []int, policy string) (int, []int) {
 	if len(offsets) <= 1 {
 		return baseNote, offsets
 	}
@@ -100,13 +227,7 @@ func (og *OrnamentGenerator) squizeOrnament(baseNote int, offsets []int) (int, [
 	mid := len(sorted) / 2
 	median := sorted[mid]
 	
-	// Filter offsets that are too far from median (Ruby's max_offset logic)
-	var filtered []int
-	for _, offset := range offsets {
-		if abs(offset-median) <= og.config.MaxOffset {
-			filtered = append(filtered, offset)
-		}
-	}
+	filtered := og.fitOffsets(offsets, median, policy)
 	
 	if len(filtered) == 0 {
 		filtered = []int{0} // Fallback to zero ornament
@@ -131,15 +252,86 @@ func (og *OrnamentGenerator) squizeOrnament(baseNote int, offsets []int) (int, [
 	return newBaseNote, newOffsets
 }
 
+// fitOffsets keeps the offsets within MaxOffset of the median. Outliers are
+// dropped (Ruby's max_offset logic) or, with OutliersFold, moved by octaves
+// toward the median; duplicates are only removed after folding.
+func (og *OrnamentGenerator) fitOffsets(offsets []int, median int, policy string) []int {
+	var fitted []int
+	folded := false
+	for _, offset := range offsets {
+		if abs(offset-median) <= og.config.MaxOffset {
+			fitted = append(fitted, offset)
+			continue
+		}
+		if policy != OutliersFold {
+			continue
+		}
+		for abs(offset-median) > og.config.MaxOffset {
+			step := 12
+			if offset > median {
+				step = -12
+			}
+			if abs(offset+step-median) >= abs(offset-median) {
+				break
+			}
+			offset += step
+		}
+		if abs(offset-median) <= og.config.MaxOffset {
+			fitted = append(fitted, offset)
+			folded = true
+		}
+	}
+	if !folded {
+		return fitted
+	}
+	
+	sort.Ints(fitted)
+	var unique []int
+	for i, offset := range fitted {
+		if i == 0 || offset != fitted[i-1] {
+			unique = append(unique, offset)
+		}
+	}
+	return unique
+}
+
 // createOrnamentString creates VTI format ornament string (Ruby's orn_txt logic)
 func (og *OrnamentGenerator) createOrnamentString(offsets []int) string {
-	var parts []string
+	var values []int
 	for _, offset := range offsets {
 		for i := 0; i < og.config.OrnRepeat; i++ {
-			parts = append(parts, fmt.Sprintf("%d", offset))
+			values = append(values, offset)
+		}
+	}
+	return ornamentKey(values, 0)
+}
+
+// ornamentKey renders ornament values in VTI format with "L" marking the loop
+func ornamentKey(values []int, loop int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(value)
+		if i == loop {
+			parts[i] = "L" + parts[i]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseOrnamentKey is the inverse of ornamentKey
+func parseOrnamentKey(key string) ([]int, int) {
+	var values []int
+	loop := 0
+	for i, part := range strings.Split(key, ",") {
+		if strings.HasPrefix(part, "L") {
+			loop = i
+			part = part[1:]
+		}
+		if val, err := strconv.Atoi(part); err == nil {
+			values = append(values, val)
 		}
 	}
-	return "L" + strings.Join(parts, ",")
+	return values, loop
 }
 
 // GetOrnaments returns all generated ornaments for output
@@ -149,14 +341,21 @@ func (og *OrnamentGenerator) GetOrnaments() map[string]int {
 
 // GenerateOrnaments creates ornaments from polyphonic channels (for output compatibility)
 func (og *OrnamentGenerator) GenerateOrnaments(timelines [][]*TimelineNote) []Ornament {
-	fmt.Println("--- making ornaments ---")
+	og.config.logf("--- making ornaments ---\n")
 	
-	var ornaments []Ornament
+	og.applyBudget(timelines)
 	
 	for i := range timelines {
-		fmt.Printf("pchan:%d\n", i)
+		og.config.logf("pchan:%d\n", i)
 	}
 	
+	return og.Ornaments()
+}
+
+// Ornaments returns the current ornament table sorted by id
+func (og *OrnamentGenerator) Ornaments() []Ornament {
+	var ornaments []Ornament
+	
 	// Convert internal ornament map to Ornament struct format
 	for ornStr, ornID := range og.ornaments {
 		if ornID == 0 {
@@ -164,18 +363,10 @@ func (og *OrnamentGenerator) GenerateOrnaments(timelines [][]*TimelineNote) []Or
 		}
 		
 		// Parse ornament string to extract pattern
-		// Format: "L0,0,4,4,7,7" -> [0,0,4,4,7,7]
-		var pattern []int
-		if strings.HasPrefix(ornStr, "L") {
-			parts := strings.Split(ornStr[1:], ",")
-			for _, part := range parts {
-				if val, err := strconv.Atoi(part); err == nil {
-					pattern = append(pattern, val)
-				}
-			}
-		}
+		// Format: "L0,0,4,4,7,7" -> [0,0,4,4,7,7], loop 0
+		pattern, loop := parseOrnamentKey(ornStr)
 		
-		ornaments = append(ornaments, Ornament{ID: ornID, Pattern: pattern})
+		ornaments = append(ornaments, Ornament{ID: ornID, Pattern: pattern, Loop: loop})
 	}
 	
 	// Sort ornaments by ID for consistent output
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFitOffsets(t *testing.T) {
+	tests := []struct {
+		maxOffset string
+		offsets   []int
+		median    int
+		policy    string
+		want      string
+	}{
+		{"12", []int{0, 4, 7, 28}, 7, OutliersDrop, "[0 4 7]"},
+		{"12", []int{0, 4, 7, 28}, 7, "", "[0 4 7]"},
+		{"12", []int{0, 4, 7, 28}, 7, OutliersFold, "[0 4 7 16]"},
+		{"12", []int{-20, 0, 4, 7}, 4, OutliersFold, "[-8 0 4 7]"},
+		{"5", []int{0, 4, 7, 16}, 7, OutliersDrop, "[4 7]"},
+		{"5", []int{0, 4, 7, 16}, 7, OutliersFold, "[4 7 12]"},
+	}
+	for _, tt := range tests {
+		config := newTestConfig(t, "in.mid", "1p", "4", "3", "6", "0", "0", "1", tt.maxOffset)
+		og := NewOrnamentGenerator(config)
+		if got := fmt.Sprint(og.fitOffsets(tt.offsets, tt.median, tt.policy)); got != tt.want {
+			t.Errorf("max %s %v %s: got %s, want %s", tt.maxOffset, tt.offsets, tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestProcessChordNotesOutliers(t *testing.T) {
+	// C2 under G4 and E5: dropping loses the root, folding keeps it as C4
+	chord := []int{36, 67, 76}
+	tests := []struct {
+		mapping string
+		base    int
+		pattern string
+	}{
+		{"1p", 67, "L0,9"},
+		{"1p{outliers=fold}", 60, "L0,7,16"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		og := NewOrnamentGenerator(newTestConfig(t))
+		base, id := og.ProcessChordNotes(append([]int(nil), chord...), &channels[0][0], -1)
+		var pattern string
+		for key, ornID := range og.GetOrnaments() {
+			if ornID == id {
+				pattern = key
+			}
+		}
+		if base != tt.base || pattern != tt.pattern {
+			t.Errorf("%q: got base %d ornament %s, want %d %s", tt.mapping, base, pattern, tt.base, tt.pattern)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadOrnamentTable reads the "[OrnamentN]" sections of a VortexTracker text
+// module or of a table written by SaveOrnamentTable. A missing file is an
+// empty table, so the first conversion can create it.
+func LoadOrnamentTable(filename string) (map[int]string, error) {
+	table := make(map[int]string)
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	id := -1
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			id = -1
+			if strings.HasPrefix(line, "[Ornament") {
+				n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "[Ornament"), "]"))
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: bad ornament section %s", filename, lineNum, line)
+				}
+				id = n
+			}
+			continue
+		}
+		if id < 1 || id > MaxOrnaments || line == "" {
+			continue
+		}
+		values, loop := parseOrnamentKey(line)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s:%d: bad ornament %d: %s", filename, lineNum, id, line)
+		}
+		table[id] = ornamentKey(values, loop)
+		id = -1
+	}
+	return table, scanner.Err()
+}
+
+// SaveOrnamentTable writes the ornaments in the module's "[OrnamentN]" format
+func SaveOrnamentTable(filename string, ornaments []Ornament) error {
+	var output strings.Builder
+	for _, ornament := range ornaments {
+		output.WriteString(fmt.Sprintf("[Ornament%d]\n%s\n\n", ornament.ID, ornamentKey(ornament.Pattern, ornament.Loop)))
+	}
+	return os.WriteFile(filename, []byte(output.String()), 0644)
+}
+
+// Seed registers a persisted ornament table. Its ornaments keep their ids
+// and new ornaments are numbered around them.
+func (og *OrnamentGenerator) Seed(table map[int]string) {
+	ids := make([]int, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		key := table[id]
+		if _, exists := og.ornaments[key]; exists {
+			continue // the same ornament twice, keep the lower id
+		}
+		og.ornaments[key] = id
+		og.seeded[id] = true
+	}
+	og.config.logf("Seeded %d ornaments\n", len(og.seeded))
+}
+
+// freeSlot returns the lowest slot not in used, preferring slots no seeded
+// ornament is reserving. It returns 0 when every slot is taken.
+func (og *OrnamentGenerator) freeSlot(used map[int]bool) int {
+	for _, avoidSeeded := range []bool{true, false} {
+		for id := 1; id <= MaxOrnaments; id++ {
+			if !used[id] && !(avoidSeeded && og.seeded[id]) {
+				return id
+			}
+		}
+	}
+	return 0
+}
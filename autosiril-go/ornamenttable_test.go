@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrnamentTableRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "song.orn")
+	ornaments := []Ornament{
+		{ID: 1, Pattern: []int{0, 4, 7}},
+		{ID: 3, Pattern: []int{12, 7, 0}, Loop: 2},
+	}
+	if err := SaveOrnamentTable(filename, ornaments); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadOrnamentTable(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "L0,4,7", 3: "12,7,L0"}
+	if !reflect.DeepEqual(table, want) {
+		t.Errorf("got %v, want %v", table, want)
+	}
+}
+
+// A module written by one conversion seeds the next with the same keys
+func TestModuleOrnamentsRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "song.txt")
+	ornaments := []Ornament{
+		{ID: 1, Pattern: []int{0, 4, 7}},
+		{ID: 2, Pattern: []int{2, 0}, Loop: 1},
+	}
+	var output strings.Builder
+	NewVortexOutputGenerator(newTestConfig(t)).writeOrnaments(&output, ornaments)
+	if err := os.WriteFile(filename, []byte(output.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := LoadOrnamentTable(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "L0,4,7", 2: "2,L0"}
+	if !reflect.DeepEqual(table, want) {
+		t.Errorf("got %v, want %v", table, want)
+	}
+}
+
+func TestLoadOrnamentTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[int]string
+		err     string
+	}{
+		{"module", "[Module]\nSpeed=4\n\n[Ornament1]\nL0,3,7\n\n[Sample1]\nTnE +000_ +00_ F_ L\n\n[Ornament2]\n0,L5\n", map[int]string{1: "L0,3,7", 2: "0,L5"}, ""},
+		{"out of range", "[Ornament16]\nL0,4\n", map[int]string{}, ""},
+		{"bad section", "[OrnamentX]\nL0,4\n", nil, ":1: bad ornament section [OrnamentX]"},
+		{"bad values", "[Ornament2]\nL,x\n", nil, ":2: bad ornament 2: L,x"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		filename := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".txt")
+		if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		table, err := LoadOrnamentTable(filename)
+		if tt.err != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(table, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, table, tt.want)
+		}
+	}
+
+	table, err := LoadOrnamentTable(filepath.Join(dir, "missing.orn"))
+	if err != nil || len(table) != 0 {
+		t.Errorf("missing file: got %v, %v, want an empty table", table, err)
+	}
+}
+
+func TestSeedKeepsIDs(t *testing.T) {
+	og := NewOrnamentGenerator(newTestConfig(t))
+	og.Seed(map[int]string{1: "L0,3,7", 2: "L0,4,7"})
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"L0,4,7", 2},
+		{"L0,5,9", 3},
+		{"L0,3,7", 1},
+		{"L0,7", 4},
+	}
+	for _, tt := range tests {
+		if got := og.register(tt.key); got != tt.want {
+			t.Errorf("register(%s) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
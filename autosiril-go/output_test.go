@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteOrnaments(t *testing.T) {
+	tests := []struct {
+		pattern []int
+		loop    int
+		want    string
+	}{
+		{[]int{0}, 0, "[Ornament1]\nL0\n\n"},
+		{[]int{0, 4, 7}, 0, "[Ornament1]\nL0,4,7\n\n"},
+		{[]int{0, 0, 3, 3}, 0, "[Ornament1]\nL0,0,3,3\n\n"},
+		{[]int{2, 0}, 1, "[Ornament1]\n2,L0\n\n"},
+	}
+	vog := NewVortexOutputGenerator(newTestConfig(t))
+	for _, tt := range tests {
+		var output strings.Builder
+		vog.writeOrnaments(&output, []Ornament{{ID: 1, Pattern: tt.pattern, Loop: tt.loop}})
+		if got := output.String(); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFormatNoteDisplay(t *testing.T) {
+	tests := []struct {
+		note *VortexNote
+		want string
+	}{
+		{&VortexNote{Type: "."}, "--- .... ...."},
+		{&VortexNote{Type: ".", Envelope: 15}, "--- .F.. ...."},
+		{&VortexNote{Type: "r"}, "R-- .... ...."},
+		{&VortexNote{Type: "s", Pitch: 0, Octave: 4, InstrumentKind: "p", Sample: 2, Ornament: 3, Volume: 15}, "C-4 2F3F ...."},
+		{&VortexNote{Type: "c", Pitch: 0, Octave: 4, InstrumentKind: "p", Sample: 2, Ornament: 3, Volume: 15}, "--- .... ...."},
+		{&VortexNote{Type: "o", Pitch: 0, Octave: 4, InstrumentKind: "p", Ornament: 5}, "--- ..5. ...."},
+		{&VortexNote{Type: "o", Pitch: 0, Octave: 4, InstrumentKind: "p", Ornament: 0}, "--- .F0. ...."},
+	}
+	vog := NewVortexOutputGenerator(newTestConfig(t))
+	for _, tt := range tests {
+		if got := vog.formatNoteDisplay(tt.note); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", *tt.note, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // PolyphonicProcessor handles note flattening and channel assignment
@@ -16,10 +17,17 @@ func NewPolyphonicProcessor(config *AutosirilConfig) *PolyphonicProcessor {
 
 // FlattenNotes converts virtual notes to timeline grid representation
 func (pp *PolyphonicProcessor) FlattenNotes(virtualNotes []*VirtualNote, maxRow int, channelSettings [][]ChannelSettings) ([][]*TimelineNote, *OrnamentGenerator, error) {
-	fmt.Println("--- flattening polynotes ---")
+	pp.config.logf("--- flattening polynotes ---\n")
 	
 	// Create ornament generator for polyphonic processing
 	ornamentGen := NewOrnamentGenerator(pp.config)
+	if pp.config.OrnamentsFile != "" {
+		table, err := LoadOrnamentTable(pp.config.OrnamentsFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading ornament table: %v", err)
+		}
+		ornamentGen.Seed(table)
+	}
 	
 	// Calculate total number of virtual channels (each channel setting gets its own virtual channel)
 	numVirtualChannels := 0
@@ -36,122 +44,241 @@ func (pp *PolyphonicProcessor) FlattenNotes(virtualNotes []*VirtualNote, maxRow
 		}
 	}
 	
-	// Create mapping from channel settings to virtual channel index (like Ruby's abs_index)
+	// Flatten each virtual channel with its own settings. Entries whose
+	// settings flatten identically (same track, same mode) share one timeline.
+	rows := maxRow + pp.config.SkipLines + 1
+	flattened := make(map[string][]*TimelineNote)
+
 	vChanIndex := 0
-	
 	for ayIdx, ayChannel := range channelSettings {
-		fmt.Printf("vchan:%d\n", ayIdx)
-		for range ayChannel {
-			// Each channel setting gets its own virtual channel, even if it references the same MIDI track
+		pp.config.logf("vchan:%d\n", ayIdx)
+		for _, chanSetting := range ayChannel {
+			held := &heldChord{note: -1, prevBase: -1}
+			key := pp.flattenKey(&chanSetting)
+			sourceTimeline, exists := flattened[key]
+			if !exists {
+				sourceTimeline = pp.flattenTrack(virtualNotes, rows, &chanSetting, ornamentGen)
+				flattened[key] = sourceTimeline
+			}
+
+			// Copy the processed timeline for this virtual channel
+			for i, note := range sourceTimeline {
+				if i < len(timelines[vChanIndex]) {
+					// Create a copy of the note with the correct instrument type
+					copyNote := &TimelineNote{
+						Note:           note.Note,
+						Volume:         note.Volume,
+						Type:           note.Type,
+						Pitch:          note.Pitch,
+						Octave:         note.Octave,
+						InstrumentKind: chanSetting.InstrumentType,
+						Channel:        note.Channel,
+						Settings:       note.Settings,
+						ChordNotes:     note.ChordNotes, // Copy chord data for ornament generation
+						GraceNotes:     note.GraceNotes,
+						Sources:        note.Sources,
+					}
+
+					// Generate ornaments for polyphonic channels
+					if chanSetting.InstrumentType == "p" && copyNote.Type == "s" && len(copyNote.ChordNotes) > 1 {
+						copyNote.Sources = ornamentGen.keptChordTones(copyNote.Sources, &chanSetting)
+						baseNote, ornamentNum := ornamentGen.ProcessChordNotes(copyNote.ChordNotes, &chanSetting, held.prevBase)
+						held.prevBase = baseNote
+						copyNote.Note = baseNote
+						copyNote.Pitch = baseNote % 12
+						copyNote.Octave = baseNote / 12
+						if copyNote.Octave > 0 {
+							copyNote.Octave--
+						}
+						// Store ornament number for later use in VortexNote
+						copyNote.Settings = fmt.Sprintf("ornament:%d", ornamentNum)
+					}
+
+					if chanSetting.InstrumentType == "p" {
+						pp.updateHeldChord(copyNote, held, ornamentGen, &chanSetting)
+					}
+
+					// Merged grace notes become the first steps of a one-shot ornament
+					if (chanSetting.InstrumentType == "m" || chanSetting.InstrumentType == "e") && copyNote.Type == "s" && len(copyNote.GraceNotes) > 0 {
+						offsets := make([]int, len(copyNote.GraceNotes))
+						for g, grace := range copyNote.GraceNotes {
+							offsets[g] = grace - copyNote.Note
+						}
+						copyNote.Settings = fmt.Sprintf("ornament:%d", ornamentGen.GraceOrnament(offsets))
+					}
+
+					// Attack ornaments on note starts that have no ornament of their own
+					if chanSetting.InstrumentType != "p" && copyNote.Type == "s" && copyNote.Settings == "" {
+						if offsets := noteAttack(copyNote.Note, &chanSetting); offsets != nil {
+							copyNote.Settings = fmt.Sprintf("ornament:%d", ornamentGen.AttackOrnament(offsets))
+						}
+					}
+
+					timelines[vChanIndex][i] = copyNote
+				}
+			}
 			vChanIndex++
 		}
 	}
-	
-	// Process notes for each unique MIDI track first, then duplicate to virtual channels
-	trackTimelines := make(map[int][]*TimelineNote)
-	
-	// Get unique MIDI tracks that are referenced
-	uniqueTracks := make(map[int]ChannelSettings)
-	for _, ayChannel := range channelSettings {
-		for _, chanSetting := range ayChannel {
-			if _, exists := uniqueTracks[chanSetting.MIDIChannel]; !exists {
-				uniqueTracks[chanSetting.MIDIChannel] = chanSetting
+
+	return timelines, ornamentGen, nil
+}
+
+// flattenMode returns the cell flattening strategy used for a setting
+func (pp *PolyphonicProcessor) flattenMode(setting *ChannelSettings) string {
+	switch setting.InstrumentType {
+	case "p":
+		return "poly"
+	case "d":
+		return "drum"
+	}
+	return "mono"
+}
+
+// flattenKey identifies settings that produce identical flattened timelines
+func (pp *PolyphonicProcessor) flattenKey(setting *ChannelSettings) string {
+	key := fmt.Sprintf("%d:%s", setting.MIDIChannel, pp.flattenMode(setting))
+	if pp.flattenMode(setting) == "mono" && !usesLegacyMono(setting) {
+		key += fmt.Sprintf(":%s:%t", setting.Priority, setting.ResumeHeld)
+	}
+	if setting.Collapse {
+		key += ":collapse"
+	}
+	if pp.flattenMode(setting) == "drum" {
+		key += fmt.Sprintf(":%s:%d", strings.Join(setting.DrumPriority, "."), setting.DrumSpill)
+	}
+	return key
+}
+
+// flattenTrack flattens all notes of the setting's MIDI track into a timeline
+func (pp *PolyphonicProcessor) flattenTrack(virtualNotes []*VirtualNote, rows int, setting *ChannelSettings, ornamentGen *OrnamentGenerator) []*TimelineNote {
+	timeline := make([]*TimelineNote, rows)
+	for j := range timeline {
+		timeline[j] = NewTimelineNote(0, 0, ".")
+	}
+
+	mode := pp.flattenMode(setting)
+	if mode == "mono" && setting.Collapse {
+		var trackNotes []*VirtualNote
+		for _, vNote := range virtualNotes {
+			if vNote.Channel == setting.MIDIChannel {
+				trackNotes = append(trackNotes, vNote)
 			}
 		}
+		virtualNotes = pp.collapseArpeggios(trackNotes, ornamentGen)
 	}
-	
-	// Process each unique track once
-	for midiTrack, setting := range uniqueTracks {
-		// Create timeline for this track
-		timeline := make([]*TimelineNote, maxRow+pp.config.SkipLines+1)
-		for j := range timeline {
-			timeline[j] = NewTimelineNote(0, 0, ".")
+	var spans []*monoSpan
+	noteCount := 0
+	for _, vNote := range virtualNotes {
+		if vNote.Channel != setting.MIDIChannel {
+			continue // Skip notes not from this MIDI track
 		}
-		
-		// Process all virtual notes that match this MIDI track
-		noteCount := 0
-		for _, vNote := range virtualNotes {
-			if vNote.Channel != midiTrack {
-				continue // Skip notes not from this MIDI track
-			}
-			
-			start := vNote.Start + pp.config.SkipLines
-			end := vNote.Off + pp.config.SkipLines
-			
-			if start < 0 || start >= len(timeline) {
-				fmt.Printf("  Note %d: skipped (start=%d, end=%d, timeline_len=%d)\n", noteCount, start, end, len(timeline))
-				continue
-			}
-			
-			noteCount++
-			
-			switch setting.InstrumentType {
-			case "m", "d", "e": // Monophonic
-				pp.processMonophonicNote(timeline, vNote, start, end, &setting)
-			case "p": // Polyphonic  
-				pp.processPolyphonicNote(timeline, vNote, start, end, &setting)
-			}
+
+		start := vNote.Start + pp.config.SkipLines
+		end := vNote.Off + pp.config.SkipLines
+
+		if start < 0 || start >= len(timeline) {
+			pp.config.logf("  Note %d: skipped (start=%d, end=%d, timeline_len=%d)\n", noteCount, start, end, len(timeline))
+			continue
+		}
+
+		noteCount++
+
+		switch {
+		case mode == "drum" || (mode == "mono" && !usesLegacyMono(setting)):
+			spans = append(spans, &monoSpan{note: vNote, start: start, end: end, order: noteCount})
+		case mode == "mono":
+			pp.processMonophonicNote(timeline, vNote, start, end, setting)
+		case mode == "poly":
+			pp.processPolyphonicNote(timeline, vNote, start, end, setting)
+			spans = append(spans, &monoSpan{note: vNote, start: start, end: end, order: noteCount})
 		}
-		fmt.Printf("Track %d processed %d notes\n", midiTrack, noteCount)
-		
-		trackTimelines[midiTrack] = timeline
 	}
-	
-	// Now assign processed track data to virtual channels
-	vChanIndex = 0
-	for _, ayChannel := range channelSettings {
-		for _, chanSetting := range ayChannel {
-	// Copy the processed timeline for this track
-			if sourceTimeline, exists := trackTimelines[chanSetting.MIDIChannel]; exists {
-				
-				for i, note := range sourceTimeline {
-					if i < len(timelines[vChanIndex]) {
-						// Create a copy of the note with the correct instrument type
-						copyNote := &TimelineNote{
-							Note:           note.Note,
-							Volume:         note.Volume,
-							Type:           note.Type,
-							Pitch:          note.Pitch,
-							Octave:         note.Octave,
-							InstrumentKind: chanSetting.InstrumentType,
-							Channel:        note.Channel,
-							Settings:       note.Settings,
-							ChordNotes:     note.ChordNotes, // Copy chord data for ornament generation
-						}
-						
-						// Generate ornaments for polyphonic channels
-						if chanSetting.InstrumentType == "p" && copyNote.Type == "s" && len(copyNote.ChordNotes) > 1 {
-								baseNote, ornamentNum := ornamentGen.ProcessChordNotes(copyNote.ChordNotes)
-							copyNote.Note = baseNote
-							copyNote.Pitch = baseNote % 12
-							copyNote.Octave = baseNote / 12
-							if copyNote.Octave > 0 {
-								copyNote.Octave--
-							}
-							// Store ornament number for later use in VortexNote
-							copyNote.Settings = fmt.Sprintf("ornament:%d", ornamentNum)
-							}
-						
-						timelines[vChanIndex][i] = copyNote
-					}
-				}
+	switch {
+	case mode == "drum":
+		pp.flattenDrums(timeline, spans, setting)
+	case mode == "poly":
+		pp.markSoundingChords(timeline, spans)
+	case len(spans) > 0:
+		pp.flattenMonophonicPriority(timeline, spans, setting)
+	}
+	pp.config.logf("Track %d processed %d notes (%s)\n", setting.MIDIChannel, noteCount, mode)
+
+	return timeline
+}
+
+// markSoundingChords stores every note sounding on a continue row in its
+// ChordNotes, so chord changes under a held note can update the ornament
+func (pp *PolyphonicProcessor) markSoundingChords(timeline []*TimelineNote, spans []*monoSpan) {
+	for _, span := range spans {
+		for pos := span.start; pos < span.end && pos < len(timeline); pos++ {
+			if timeline[pos].Type == "c" {
+				timeline[pos].ChordNotes = append(timeline[pos].ChordNotes, span.note.Note)
 			}
-			vChanIndex++
 		}
 	}
-	
-	return timelines, ornamentGen, nil
 }
 
-func (pp *PolyphonicProcessor) getChannelSetting(midiChannel int, channelSettings [][]ChannelSettings) *ChannelSettings {
-	for _, ayChannel := range channelSettings {
-		for _, setting := range ayChannel {
-			if setting.MIDIChannel == midiChannel {
-				return &setting
-			}
+// updateHeldChord re-evaluates a continue cell of a polyphonic channel. When
+// the sounding chord differs from the last one while the held note keeps
+// sounding, the cell becomes an "o" cell that only switches the ornament.
+func (pp *PolyphonicProcessor) updateHeldChord(note *TimelineNote, held *heldChord, ornamentGen *OrnamentGenerator, setting *ChannelSettings) {
+	switch note.Type {
+	case "s":
+		held.note = note.Note
+		held.chord = chordKey(note.ChordNotes)
+		held.ornament = note.Settings
+		return
+	case "c":
+	default:
+		held.note = -1
+		return
+	}
+
+	if held.note < 0 || len(note.ChordNotes) == 0 {
+		return
+	}
+	if key := chordKey(note.ChordNotes); key != held.chord {
+		ornamentNum, ok := ornamentGen.HeldChordOrnament(held.note, note.ChordNotes, setting)
+		if !ok {
+			held.note = -1 // The held note stopped sounding
+			return
+		}
+		held.chord = key
+		settings := fmt.Sprintf("ornament:%d", ornamentNum)
+		if settings != held.ornament && !(held.ornament == "" && ornamentNum == 0) {
+			held.ornament = settings
+			note.Type = "o"
+		}
+	}
+
+	// Continue and ornament cells carry the held chord, so a retriggering
+	// channel repeats the right note and ornament
+	note.Note = held.note
+	note.calculatePitchOctave()
+	note.Settings = held.ornament
+}
+
+// heldChord tracks the note and chord currently sounding on a "p" channel
+type heldChord struct {
+	note     int
+	chord    string
+	ornament string
+	prevBase int // base note of the previous chord, for closest voicing
+}
+
+// chordKey returns a canonical key for a set of chord notes
+func chordKey(notes []int) string {
+	sorted := make([]int, len(notes))
+	copy(sorted, notes)
+	sort.Ints(sorted)
+	var parts []string
+	for i, n := range sorted {
+		if i == 0 || n != sorted[i-1] {
+			parts = append(parts, fmt.Sprintf("%d", n))
 		}
 	}
-	return nil
+	return strings.Join(parts, ",")
 }
 
 func (pp *PolyphonicProcessor) processMonophonicNote(timeline []*TimelineNote, vNote *VirtualNote, start, end int, setting *ChannelSettings) {
@@ -165,12 +292,16 @@ func (pp *PolyphonicProcessor) processMonophonicNote(timeline []*TimelineNote, v
 				timeline[pos].InstrumentKind = setting.InstrumentType
 				timeline[pos].Channel = vNote.Channel
 				timeline[pos].Settings = vNote.Settings
+				timeline[pos].GraceNotes = vNote.GraceNotes
+				timeline[pos].Sources = []*VirtualNote{vNote}
 			} else if timeline[pos].Type == "s" && vNote.Note > timeline[pos].Note {
 				// Existing start note - take highest note (Ruby's cell.max behavior)
 				timeline[pos] = NewTimelineNote(vNote.Note, vNote.Volume, "s")
 				timeline[pos].InstrumentKind = setting.InstrumentType
 				timeline[pos].Channel = vNote.Channel
 				timeline[pos].Settings = vNote.Settings
+				timeline[pos].GraceNotes = vNote.GraceNotes
+				timeline[pos].Sources = []*VirtualNote{vNote}
 }
 			// If there's already a higher note, don't place this one
 		} else if pos == end-1 {
@@ -202,12 +333,14 @@ func (pp *PolyphonicProcessor) processPolyphonicNote(timeline []*TimelineNote, v
 				timeline[pos].Settings = vNote.Settings
 				// Mark this note as part of a chord for ornament generation
 				timeline[pos].ChordNotes = []int{vNote.Note}
+				timeline[pos].Sources = []*VirtualNote{vNote}
 			} else if timeline[pos].Type == "s" {
 				// Existing start note - add to chord (polyphonic behavior)
 				if timeline[pos].ChordNotes == nil {
 					timeline[pos].ChordNotes = []int{timeline[pos].Note}
 				}
 				timeline[pos].ChordNotes = append(timeline[pos].ChordNotes, vNote.Note)
+				timeline[pos].Sources = append(timeline[pos].Sources, vNote)
 				// Keep the lowest note as base note (Ruby uses pcell.min)
 				if vNote.Note < timeline[pos].Note {
 					timeline[pos].Note = vNote.Note
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFlattenNotesPerVirtualChannel(t *testing.T) {
+	config := newTestConfig(t)
+	channels, err := parseChannelMapping("3p,3m,3d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 3},
+		{Note: 64, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 3},
+	}
+	timelines, _, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 4, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		vchan int
+		types string
+		kind  string
+		note  int
+	}{
+		{0, "scc", "p", 60}, // chord folded into an ornament on the lowest note
+		{1, "s..", "m", 64}, // highest note wins, continues are dropped
+		{2, "s..", "d", 60}, // drum flattening keeps the lowest hit of a class
+	}
+	for _, tt := range tests {
+		types := ""
+		for _, cell := range timelines[tt.vchan][:3] {
+			types += cell.Type
+		}
+		if types != tt.types {
+			t.Errorf("vchan %d: types %q, want %q", tt.vchan, types, tt.types)
+		}
+		first := timelines[tt.vchan][0]
+		if first.InstrumentKind != tt.kind || first.Note != tt.note {
+			t.Errorf("vchan %d: row 0 = %s %d, want %s %d", tt.vchan, first.InstrumentKind, first.Note, tt.kind, tt.note)
+		}
+	}
+}
+
+func TestFlattenKey(t *testing.T) {
+	pp := NewPolyphonicProcessor(newTestConfig(t))
+	tests := []struct {
+		a, b  string
+		share bool
+	}{
+		{"3m", "3d", false},
+		{"3m", "3me", true},
+		{"3m", "3p", false},
+		{"3p", "3p[4]", true},
+		{"3m", "4m", false},
+	}
+	for _, tt := range tests {
+		a, errA := parseChannelMapping(tt.a)
+		b, errB := parseChannelMapping(tt.b)
+		if errA != nil || errB != nil {
+			t.Fatalf("%q/%q: %v %v", tt.a, tt.b, errA, errB)
+		}
+		share := pp.flattenKey(&a[0][0]) == pp.flattenKey(&b[0][0])
+		if share != tt.share {
+			t.Errorf("%q and %q share a timeline: %v, want %v", tt.a, tt.b, share, tt.share)
+		}
+	}
+}
+
+func TestHeldChordOrnamentChange(t *testing.T) {
+	channels, err := parseChannelMapping("1p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bass 48 held for 9 rows, upper voice 55, then 52, then nothing
+	notes := []*VirtualNote{
+		{Note: 48, Volume: 15, Start: 0, Off: 9, Channel: 1},
+		{Note: 55, Volume: 15, Start: 0, Off: 3, Channel: 1},
+		{Note: 52, Volume: 15, Start: 3, Off: 6, Channel: 1},
+	}
+	timelines, ornamentGen, err := NewPolyphonicProcessor(newTestConfig(t)).FlattenNotes(notes, 9, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types string
+	for _, cell := range timelines[0][:9] {
+		types += cell.Type
+	}
+	if want := "sccoccocr"; types != want {
+		t.Fatalf("types %q, want %q", types, want)
+	}
+
+	ornaments := make(map[string][]int)
+	for _, orn := range ornamentGen.GenerateOrnaments(timelines) {
+		ornaments[fmt.Sprintf("ornament:%d", orn.ID)] = orn.Pattern
+	}
+	tests := []struct {
+		row     int
+		pattern string
+	}{
+		{0, "[0 7]"},
+		{3, "[0 4]"},
+	}
+	for _, tt := range tests {
+		cell := timelines[0][tt.row]
+		if cell.Note != 48 {
+			t.Errorf("row %d: note %d, want the held 48", tt.row, cell.Note)
+		}
+		if got := fmt.Sprint(ornaments[cell.Settings]); got != tt.pattern {
+			t.Errorf("row %d: ornament %s = %s, want %s", tt.row, cell.Settings, got, tt.pattern)
+		}
+	}
+	if got := timelines[0][6].Settings; got != "ornament:0" {
+		t.Errorf("row 6: settings %q, want ornament:0 once the chord is gone", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import "sort"
+
+// Monophonic note priority modes selected with "{prio=...}" in the mapping
+const (
+	PriorityHigh  = "high"  // highest note wins (Ruby's cell.max, default)
+	PriorityLow   = "low"   // lowest note wins, for bass lines
+	PriorityLast  = "last"  // newest note wins, for leads
+	PriorityFirst = "first" // held note wins until released
+	PriorityLoud  = "loud"  // highest MIDI velocity wins
+)
+
+// usesLegacyMono reports whether a setting flattens with the Ruby-compatible
+// per-cell logic of processMonophonicNote instead of the priority sweep
+func usesLegacyMono(setting *ChannelSettings) bool {
+	return (setting.Priority == "" || setting.Priority == PriorityHigh) && !setting.ResumeHeld
+}
+
+// monoSpan is a note placed on the timeline rows [start, end)
+type monoSpan struct {
+	note  *VirtualNote
+	start int
+	end   int
+	order int
+}
+
+// prefer reports whether a should win over b under the given priority
+func (a *monoSpan) prefer(b *monoSpan, priority string) bool {
+	switch priority {
+	case PriorityLow:
+		if a.note.Note != b.note.Note {
+			return a.note.Note < b.note.Note
+		}
+	case PriorityLast:
+		if a.start != b.start {
+			return a.start > b.start
+		}
+		if a.order != b.order {
+			return a.order > b.order
+		}
+	case PriorityFirst:
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		if a.order != b.order {
+			return a.order < b.order
+		}
+	case PriorityLoud:
+		if a.note.Velocity != b.note.Velocity {
+			return a.note.Velocity > b.note.Velocity
+		}
+	}
+	return a.note.Note > b.note.Note
+}
+
+// flattenMonophonicPriority places notes on a monophonic timeline by sweeping
+// the rows and choosing one sounding note per row with the setting's priority.
+// Like processMonophonicNote, continues stay empty and a note's last row
+// carries its release. With ResumeHeld a still held note is started again
+// when the note that interrupted it ends.
+func (pp *PolyphonicProcessor) flattenMonophonicPriority(timeline []*TimelineNote, spans []*monoSpan, setting *ChannelSettings) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	place := func(pos int, span *monoSpan, noteType string) {
+		timeline[pos] = NewTimelineNote(span.note.Note, span.note.Volume, noteType)
+		timeline[pos].InstrumentKind = setting.InstrumentType
+		timeline[pos].Channel = span.note.Channel
+		timeline[pos].Settings = span.note.Settings
+		if noteType == "s" {
+			timeline[pos].GraceNotes = span.note.GraceNotes
+			timeline[pos].Sources = []*VirtualNote{span.note}
+		}
+	}
+
+	activeAt := func(pos int, span *monoSpan) bool {
+		return span.start <= pos && pos < span.end
+	}
+
+	var current *monoSpan
+	next := 0
+	var active []*monoSpan
+	for pos := 0; pos < len(timeline); pos++ {
+		// Drop ended notes, add notes starting on this row
+		kept := active[:0]
+		for _, span := range active {
+			if activeAt(pos, span) {
+				kept = append(kept, span)
+			}
+		}
+		active = kept
+		for next < len(spans) && spans[next].start <= pos {
+			if activeAt(pos, spans[next]) {
+				active = append(active, spans[next])
+			}
+			next++
+		}
+
+		// Without ResumeHeld only new notes may take over the current one
+		var winner *monoSpan
+		for _, span := range active {
+			if !setting.ResumeHeld && span.start != pos && span != current {
+				continue
+			}
+			if winner == nil || span.prefer(winner, setting.Priority) {
+				winner = span
+			}
+		}
+
+		started := false
+		if winner != nil && winner != current {
+			place(pos, winner, "s")
+			current = winner
+			started = true
+		}
+
+		if current != nil && pos == current.end-1 {
+			resumes := false
+			if setting.ResumeHeld {
+				for _, span := range active {
+					if span != current && activeAt(pos+1, span) {
+						resumes = true
+						break
+					}
+				}
+			}
+			if !started && !resumes {
+				place(pos, current, "r")
+			}
+			current = nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// renderCells formats the non-empty cells of a timeline as "row:type note"
+func renderCells(timeline []*TimelineNote) string {
+	var cells []string
+	for row, cell := range timeline {
+		if cell.Type != "." {
+			cells = append(cells, fmt.Sprintf("%d:%s%d", row, cell.Type, cell.Note))
+		}
+	}
+	return strings.Join(cells, " ")
+}
+
+func TestMonophonicPriority(t *testing.T) {
+	chord := []*VirtualNote{
+		{Note: 60, Volume: 15, Velocity: 100, Start: 0, Off: 4, Channel: 1},
+		{Note: 64, Volume: 15, Velocity: 50, Start: 0, Off: 4, Channel: 1},
+	}
+	held := []*VirtualNote{
+		{Note: 60, Volume: 15, Velocity: 80, Start: 0, Off: 8, Channel: 1},
+		{Note: 67, Volume: 15, Velocity: 80, Start: 2, Off: 4, Channel: 1},
+	}
+	tests := []struct {
+		mapping string
+		notes   []*VirtualNote
+		want    string
+	}{
+		{"1", chord, "0:s64 3:r60"},
+		{"1{prio=high}", chord, "0:s64 3:r60"},
+		{"1{prio=low}", chord, "0:s60 3:r60"},
+		{"1{prio=loud}", chord, "0:s60 3:r60"},
+		{"1", held, "0:s60 2:s67 3:r67 7:r60"},
+		{"1{prio=last}", held, "0:s60 2:s67 3:r67"}, // interrupted note stays cut
+		{"1{prio=first}", held, "0:s60 7:r60"},
+		{"1{prio=low}", held, "0:s60 7:r60"},
+		{"1{prio=last,resume}", held, "0:s60 2:s67 4:s60 7:r60"},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		timelines, _, err := NewPolyphonicProcessor(newTestConfig(t)).FlattenNotes(tt.notes, 8, channels)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		if got := renderCells(timelines[0]); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+)
+
+// sampleRows returns how many rows a module sample sounds: up to its loop
+// line when the loop is silent, otherwise one pass through all lines
+func sampleRows(sample int) int {
+	if sample < 1 || sample > len(moduleSamples) {
+		return 1
+	}
+	lines := strings.Split(strings.TrimSpace(moduleSamples[sample-1]), "\n")[1:]
+	frames := len(lines)
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if strings.HasSuffix(line, " L") && len(fields) >= 4 && strings.HasPrefix(fields[3], "0") {
+			frames = i
+			break
+		}
+	}
+	rows := (frames + framesPerRow - 1) / framesPerRow
+	if rows < 1 {
+		rows = 1
+	}
+	return rows
+}
+
+// melodyState follows the note sounding on an AY channel
+type melodyState struct {
+	note          *VortexNote // start of the sounding note, nil when silent
+	start         int         // row of the start
+	ornament      int         // current ornament, changed by "o" rows
+	ornamentStart int         // row the current ornament was set and restarted
+}
+
+// update follows the cell at row pos. Drum hits end with their sample, as
+// drum channels write no release rows.
+func (ms *melodyState) update(pos int, cell *VortexNote) {
+	switch cell.Type {
+	case "s":
+		ms.note = cell
+		ms.start = pos
+		ms.ornament = cell.Ornament
+		ms.ornamentStart = pos
+	case "o":
+		ms.ornament = cell.Ornament
+		ms.ornamentStart = pos
+	case "r":
+		ms.note = nil
+	}
+	if ms.note != nil && ms.note.InstrumentKind == "d" && pos >= ms.start+sampleRows(ms.note.Sample) {
+		ms.note = nil
+	}
+}
+
+// stealDrums lets a "{steal}" drum entry take over an AY channel for the
+// length of each hit's sample. Rows the drum holds are emptied so the
+// sample plays out, and the melody note still sounding afterwards is
+// played again with its volume and the rest of its current ornament.
+func (cm *ChannelMixer) stealDrums(channel []*VortexNote, timeline []*TimelineNote, setting *ChannelSettings, ayIdx int) {
+	melody := make([]*VortexNote, len(channel))
+	copy(melody, channel)
+
+	var state melodyState
+	hits, restored := 0, 0
+	stolenUntil := -1
+	for pos := range channel {
+		state.update(pos, melody[pos])
+
+		// Echo taps of the drums never take the channel from the melody
+		hit := pos < len(timeline) && timeline[pos].Type == "s" && !timeline[pos].Echo
+		if hit {
+			drum := NewVortexNote(timeline[pos])
+			drum.InstrumentKind = setting.InstrumentType
+			cm.applyInstrumentSettings(drum, setting)
+			channel[pos] = drum
+			hits++
+
+			rows := setting.StealRows
+			if rows == 0 {
+				rows = sampleRows(drum.Sample)
+			}
+			stolenUntil = pos + rows
+			continue
+		}
+
+		switch {
+		case pos < stolenUntil:
+			channel[pos] = NewVortexNote(NewTimelineNote(0, 0, "."))
+		case pos == stolenUntil && state.note != nil && melody[pos].Type != "s" && melody[pos].Type != "r":
+			resumed := *state.note
+			resumed.Ornament = cm.ornaments.ResumedOrnament(state.ornament, (pos-state.ornamentStart)*framesPerRow)
+			channel[pos] = &resumed
+			restored++
+		}
+	}
+	cm.config.logf("drum steal of track %d on channel %c: %d hits, %d melody notes resumed\n",
+		setting.MIDIChannel, 'A'+ayIdx, hits, restored)
+}
+
+// ResumedOrnament returns an ornament playing the rest of ornament id from
+// the given frame on: the remaining one-shot steps, or the loop rotated to
+// the current step. Without a free slot the ornament restarts.
+func (og *OrnamentGenerator) ResumedOrnament(id, frames int) int {
+	if id == 0 {
+		return id
+	}
+	var values []int
+	loop := 0
+	for key, keyID := range og.ornaments {
+		if keyID == id {
+			values, loop = parseOrnamentKey(key)
+			break
+		}
+	}
+	if len(values) == 0 {
+		return id
+	}
+
+	step := frames
+	if step >= len(values) {
+		step = loop + (frames-loop)%(len(values)-loop)
+	}
+	if step == 0 {
+		return id
+	}
+	var key string
+	if step < loop {
+		key = ornamentKey(values[step:], loop-step)
+	} else {
+		rotated := append(append([]int{}, values[step:]...), values[loop:step]...)
+		key = ornamentKey(rotated, 0)
+	}
+	if resumed, ok := og.AddOrnament(key); ok {
+		return resumed
+	}
+	return id
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSampleRows(t *testing.T) {
+	tests := []struct {
+		sample int
+		want   int
+	}{
+		{0, 1},
+		{1, 2}, // six frames, loops on an audible line
+		{2, 1},
+		{5, 1}, // silent loop after two frames
+		{9, 5}, // twenty frames before the silent loop
+		{99, 1},
+	}
+	for _, tt := range tests {
+		if got := sampleRows(tt.sample); got != tt.want {
+			t.Errorf("sampleRows(%d) = %d, want %d", tt.sample, got, tt.want)
+		}
+	}
+}
+
+func TestStealDrums(t *testing.T) {
+	melody := &VirtualNote{Note: 60, Volume: 15, Start: 0, Off: 8, Length: 8, Channel: 1}
+	tests := []struct {
+		mapping string
+		hits    []int
+		want    string
+	}{
+		{"1m-2d", []int{2}, "0:s60/2 2:s60/10 7:r60/2"},
+		{"1m-2d{steal=1}", []int{2}, "0:s60/2 2:s60/10 3:s60/2 7:r60/2"},
+		{"1m-2d{steal=5}", []int{2}, "0:s60/2 2:s60/10 7:r60/2"},
+		{"1m-2d{steal=2}", []int{2, 3}, "0:s60/2 2:s60/10 3:s60/10 5:s60/2 7:r60/2"},
+		{"1m-2d{steal}", []int{2}, "0:s60/2 2:s60/10 4:s60/2 7:r60/2"},        // kick sample 10 sounds two rows
+		{"1m-2d{steal,echo=3}", []int{2}, "0:s60/2 2:s60/10 4:s60/2 7:r60/2"}, // the echo on row 5 is no hit
+	}
+	for _, tt := range tests {
+		notes := []*VirtualNote{melody}
+		for _, row := range tt.hits {
+			notes = append(notes, &VirtualNote{Note: 36, Volume: 15, Start: row, Off: row + 1, Length: 1, Channel: 2})
+		}
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.mapping, err)
+		}
+		config := newTestConfig(t)
+		timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 8, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timelines, _ = NewEchoProcessor(config).ApplyEcho(timelines, channels)
+		var cells []string
+		for row, cell := range NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, nil)[0] {
+			if cell.Type != "." {
+				cells = append(cells, fmt.Sprintf("%d:%s%d/%d", row, cell.Type, cell.Note, cell.Sample))
+			}
+		}
+		if got := strings.Join(cells, " "); got != tt.want {
+			t.Errorf("%q hits %v: got %q, want %q", tt.mapping, tt.hits, got, tt.want)
+		}
+	}
+}
+
+func TestStealResumesOrnamentPhase(t *testing.T) {
+	chord := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 8, Length: 8, Channel: 1},
+		{Note: 64, Volume: 15, Start: 0, Off: 8, Length: 8, Channel: 1},
+		{Note: 67, Volume: 15, Start: 0, Off: 8, Length: 8, Channel: 1},
+	}
+	tests := []struct {
+		hit  int
+		want string // ornament of the resumed chord
+	}{
+		{2, "L4,7,0"}, // 16 frames into the 3-step loop
+		{1, "L0,4,7"}, // 12 frames, back at the first step
+		{3, "L7,0,4"}, // 20 frames
+	}
+	for _, tt := range tests {
+		notes := append(append([]*VirtualNote{}, chord...), &VirtualNote{Note: 36, Volume: 15, Start: tt.hit, Off: tt.hit + 1, Length: 1, Channel: 2})
+		channels, err := parseChannelMapping("1p-2d{steal}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := newTestConfig(t)
+		timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 10, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ornamentGen.GenerateOrnaments(timelines)
+		cells := NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, nil)[0]
+		keys := make(map[int]string)
+		for key, id := range ornamentGen.GetOrnaments() {
+			keys[id] = key
+		}
+
+		if got := keys[cells[0].Ornament]; got != "L0,4,7" {
+			t.Fatalf("hit on row %d: chord starts with ornament %q, want L0,4,7", tt.hit, got)
+		}
+		resumed := cells[tt.hit+2]
+		if resumed.Type != "s" || keys[resumed.Ornament] != tt.want {
+			t.Errorf("hit on row %d: resumed %s with ornament %q, want %q", tt.hit, resumed.Type, keys[resumed.Ornament], tt.want)
+		}
+	}
+}
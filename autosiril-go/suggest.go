@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Track roles assigned by the mapping suggester
+const (
+	RoleDrums  = "drums"
+	RoleBass   = "bass"
+	RoleChords = "chords"
+	RoleLead   = "lead"
+	RoleMelody = "melody"
+)
+
+// suggestBeamWidth is how many partial mappings the search keeps per track
+const suggestBeamWidth = 64
+
+// TrackProfile summarizes one MIDI track for mapping suggestions
+type TrackProfile struct {
+	Track      int
+	Notes      int
+	Low, High  int
+	Mean       float64 // average pitch
+	Polyphony  int     // most notes sounding on one row
+	ChordShare float64 // share of sounding rows with two or more notes
+	Density    float64 // note starts per row between the first start and the last release
+	AvgLength  float64 // rows
+	DrumScore  float64 // 0..1, how much the track looks like percussion
+	Role       string
+	Setting    ChannelSettings // entry proposed for the track
+	Reason     string
+	sounding   []bool
+}
+
+// MappingSuggestion is one proposed channel mapping with its estimated cost
+type MappingSuggestion struct {
+	Mapping    string
+	Channels   [][]ChannelSettings
+	Collisions int     // rows where two entries of one AY channel sound together
+	Cost       float64 // collisions weighted by the importance of the losing track
+}
+
+// MappingSuggester proposes channel mappings from an analysis of the tracks
+type MappingSuggester struct {
+	config *AutosirilConfig
+}
+
+func NewMappingSuggester(config *AutosirilConfig) *MappingSuggester {
+	return &MappingSuggester{config: config}
+}
+
+// RunSuggest implements "autosiril suggest INPUT_FILE [PER_BEAT] [--flags]"
+func RunSuggest(args []string) error {
+	var flags, positional []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			flags = append(flags, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		return fmt.Errorf("usage: autosiril suggest INPUT_FILE [PER_BEAT] [--suggestions=N]")
+	}
+	// Same argument order as a conversion, without the mapping
+	configArgs := append(flags, positional[0], "")
+	configArgs = append(configArgs, positional[1:]...)
+	config, err := NewAutosirilConfig(configArgs)
+	if err != nil {
+		return err
+	}
+
+	virtualNotes, maxRow, err := NewMidiProcessor(config).LoadMIDI()
+	if err != nil {
+		return err
+	}
+
+	suggester := NewMappingSuggester(config)
+	profiles := suggester.Analyze(virtualNotes, maxRow)
+	if len(profiles) == 0 {
+		return fmt.Errorf("no notes found in %s", config.InputFile)
+	}
+	fmt.Println("--- suggesting mappings ---")
+	for _, profile := range profiles {
+		fmt.Printf("track %d: %d notes %s..%s, polyphony %d, chords %.0f%%, %.2f notes/row, length %.1f rows, drums %.0f%% -> %s %s (%s)\n",
+			profile.Track, profile.Notes, noteName(profile.Low), noteName(profile.High), profile.Polyphony,
+			profile.ChordShare*100, profile.Density, profile.AvgLength, profile.DrumScore*100,
+			profile.Role, formatEntry(profile.Setting), profile.Reason)
+	}
+	suggestions, err := suggester.Suggest(profiles)
+	if err != nil {
+		return err
+	}
+	for i, suggestion := range suggestions {
+		fmt.Printf("%d. \"%s\"  %d collision rows (weighted %.0f)\n", i+1, suggestion.Mapping, suggestion.Collisions, suggestion.Cost)
+	}
+	return nil
+}
+
+// Analyze profiles every track with notes and proposes an entry for it
+func (ms *MappingSuggester) Analyze(virtualNotes []*VirtualNote, maxRow int) []*TrackProfile {
+	byTrack := make(map[int][]*VirtualNote)
+	var tracks []int
+	for _, vNote := range virtualNotes {
+		if _, exists := byTrack[vNote.Channel]; !exists {
+			tracks = append(tracks, vNote.Channel)
+		}
+		byTrack[vNote.Channel] = append(byTrack[vNote.Channel], vNote)
+	}
+	sort.Ints(tracks)
+
+	var profiles []*TrackProfile
+	for _, track := range tracks {
+		profiles = append(profiles, ms.profile(track, byTrack[track], maxRow+1))
+	}
+	ms.assignRoles(profiles)
+	return profiles
+}
+
+func (ms *MappingSuggester) profile(track int, notes []*VirtualNote, rows int) *TrackProfile {
+	p := &TrackProfile{Track: track, Notes: len(notes), Low: notes[0].Note, High: notes[0].Note, sounding: make([]bool, rows)}
+	count := make([]int, rows)
+	first, last := rows, 0
+	sum, length, short, gmDrums := 0, 0, 0, 0
+	pitches := make(map[int]bool)
+	for _, vNote := range notes {
+		p.Low, p.High = min(p.Low, vNote.Note), max(p.High, vNote.Note)
+		sum += vNote.Note
+		length += vNote.Length
+		pitches[vNote.Note] = true
+		if vNote.Length <= 1 {
+			short++
+		}
+		if vNote.GMDrums {
+			gmDrums++
+		}
+		end := max(vNote.Off, vNote.Start+1)
+		first, last = min(first, vNote.Start), max(last, end)
+		for pos := vNote.Start; pos < end && pos < rows; pos++ {
+			count[pos]++
+		}
+	}
+
+	sounding, chords := 0, 0
+	for pos, n := range count {
+		p.Polyphony = max(p.Polyphony, n)
+		if n > 0 {
+			p.sounding[pos] = true
+			sounding++
+		}
+		if n > 1 {
+			chords++
+		}
+	}
+	p.Mean = float64(sum) / float64(len(notes))
+	p.AvgLength = float64(length) / float64(len(notes))
+	if sounding > 0 {
+		p.ChordShare = float64(chords) / float64(sounding)
+	}
+	if last > first {
+		p.Density = float64(len(notes)) / float64(last-first)
+	}
+
+	// General MIDI channel 10 decides; otherwise short hits on few pitches
+	// spanning at most two octaves of the GM percussion range look like drums
+	if gmDrums*2 > len(notes) {
+		p.DrumScore = 1
+	} else if p.Low >= 35 && p.High <= 81 && p.High-p.Low <= 24 && p.AvgLength <= 2 {
+		repeats := 1 - float64(len(pitches))/float64(len(notes))
+		p.DrumScore = 0.5*float64(short)/float64(len(notes)) + 0.4*repeats
+	}
+	return p
+}
+
+// assignRoles picks each track's role and entry. At most one track gets the
+// envelope, as the AY has a single envelope generator.
+func (ms *MappingSuggester) assignRoles(profiles []*TrackProfile) {
+	var bass, lead *TrackProfile
+	for _, p := range profiles {
+		switch {
+		case p.DrumScore >= 0.75:
+			p.Role = RoleDrums
+		case p.ChordShare >= 0.3 && p.Polyphony >= 3:
+			p.Role = RoleChords
+		default:
+			p.Role = RoleMelody
+			if p.Mean < 48 && (bass == nil || p.Mean < bass.Mean) {
+				bass = p
+			}
+		}
+	}
+	if bass != nil {
+		bass.Role = RoleBass
+	}
+	for _, p := range profiles {
+		if p.Role == RoleMelody && (lead == nil || p.Notes*int(p.Mean) > lead.Notes*int(lead.Mean)) {
+			lead = p
+		}
+	}
+	if lead != nil {
+		lead.Role = RoleLead
+	}
+
+	for _, p := range profiles {
+		setting := ChannelSettings{
+			MIDIChannel:    p.Track,
+			InstrumentType: "m",
+			Sample:         2,
+			MixOption:      "-",
+			EchoOrnament:   -1,
+			PingPong:       -1,
+		}
+		switch p.Role {
+		case RoleDrums:
+			setting.InstrumentType = "d"
+			setting.Modifiers = "u"
+			p.Reason = "percussion, echo muted"
+		case RoleChords:
+			setting.InstrumentType = "p"
+			setting.Sample = 3
+			p.Reason = "chords as ornaments on the decaying sample 3"
+		case RoleBass:
+			setting.InstrumentType = "e"
+			p.Reason = "lowest line on the envelope"
+			if p.Polyphony > 1 {
+				setting.Priority = PriorityLow
+				p.Reason += ", lowest note wins"
+			}
+		default:
+			p.Reason = "held notes on sample 2"
+			if p.AvgLength <= 2 {
+				setting.Sample = 1
+				p.Reason = "short notes on the plucked sample 1"
+			} else if p.Role == RoleMelody {
+				setting.Sample = 6
+				p.Reason = "second voice on the detuned sample 6"
+			}
+			if p.Polyphony > 1 && p.ChordShare >= 0.1 {
+				setting.Priority = PriorityLast
+				p.Reason += ", newest note wins"
+			}
+		}
+		p.Setting = setting
+	}
+}
+
+// roleWeight is how much losing a row of the role costs
+func roleWeight(role string) float64 {
+	switch role {
+	case RoleLead:
+		return 3
+	case RoleBass:
+		return 2.5
+	case RoleDrums, RoleMelody:
+		return 2
+	}
+	return 1.5
+}
+
+// pairCost estimates what two tracks on one AY channel lose to each other
+func pairCost(a, b *TrackProfile) (int, float64) {
+	rows := 0
+	for pos := range a.sounding {
+		if a.sounding[pos] && pos < len(b.sounding) && b.sounding[pos] {
+			rows++
+		}
+	}
+	cost := float64(rows) * minFloat(roleWeight(a.Role), roleWeight(b.Role))
+	if (a.Role == RoleDrums) != (b.Role == RoleDrums) {
+		cost *= 0.35 // the drums steal the channel and the melody resumes
+	}
+	return rows, cost
+}
+
+// partialMapping is a state of the beam search: tracks placed on AY channels
+type partialMapping struct {
+	groups [3][]int // profile indices
+	cost   float64
+}
+
+// key identifies mappings that only differ by the order of AY channels
+func (pm *partialMapping) key() string {
+	parts := make([]string, 3)
+	for i, group := range pm.groups {
+		parts[i] = fmt.Sprint(group)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|")
+}
+
+// Suggest places the tracks on the three AY channels with a beam search over
+// the most important tracks first and returns the best distinct mappings.
+// A mapping that its own parser rejects is a bug in the formatting and
+// fails the search.
+func (ms *MappingSuggester) Suggest(profiles []*TrackProfile) ([]MappingSuggestion, error) {
+	order := make([]int, len(profiles))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := profiles[order[i]], profiles[order[j]]
+		return roleWeight(a.Role)*float64(a.Notes) > roleWeight(b.Role)*float64(b.Notes)
+	})
+
+	beam := []*partialMapping{{}}
+	for _, idx := range order {
+		seen := make(map[string]bool)
+		var next []*partialMapping
+		for _, state := range beam {
+			for ch := 0; ch < 3; ch++ {
+				grown := &partialMapping{groups: state.groups, cost: state.cost}
+				for _, other := range state.groups[ch] {
+					_, cost := pairCost(profiles[idx], profiles[other])
+					grown.cost += cost
+				}
+				grown.groups[ch] = append(append([]int{}, state.groups[ch]...), idx)
+				if key := grown.key(); !seen[key] {
+					seen[key] = true
+					next = append(next, grown)
+				}
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool { return next[i].cost < next[j].cost })
+		if len(next) > suggestBeamWidth {
+			next = next[:suggestBeamWidth]
+		}
+		beam = next
+	}
+
+	var suggestions []MappingSuggestion
+	for _, state := range beam {
+		if len(suggestions) == ms.config.Suggestions {
+			break
+		}
+		channels := ms.arrange(state, profiles)
+		mapping := FormatChannelMapping(channels)
+		if _, err := parseChannelMapping(mapping); err != nil {
+			return nil, fmt.Errorf("suggested mapping '%s' does not parse: %v", mapping, err)
+		}
+		collisions := 0
+		for _, group := range state.groups {
+			for i := range group {
+				for j := i + 1; j < len(group); j++ {
+					rows, _ := pairCost(profiles[group[i]], profiles[group[j]])
+					collisions += rows
+				}
+			}
+		}
+		suggestions = append(suggestions, MappingSuggestion{Mapping: mapping, Channels: channels, Collisions: collisions, Cost: state.cost})
+	}
+	return suggestions, nil
+}
+
+// arrange turns a placement into AY channels: the lead in the middle (B),
+// drums on A, and on each channel drums first, then by importance. Drums
+// sharing a channel with melody steal it. No entry gets the "+" mix option,
+// which the mixer doesn't read.
+func (ms *MappingSuggester) arrange(state *partialMapping, profiles []*TrackProfile) [][]ChannelSettings {
+	var groups [][]int
+	for _, group := range state.groups {
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	has := func(group []int, role string) bool {
+		for _, idx := range group {
+			if profiles[idx].Role == role {
+				return true
+			}
+		}
+		return false
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return has(groups[i], RoleDrums) && !has(groups[j], RoleDrums)
+	})
+	if len(groups) == 3 {
+		for i, group := range groups {
+			if has(group, RoleLead) {
+				groups[1], groups[i] = groups[i], groups[1]
+				break
+			}
+		}
+	}
+
+	channels := make([][]ChannelSettings, len(groups))
+	for ch, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			a, b := profiles[group[i]], profiles[group[j]]
+			if (a.Role == RoleDrums) != (b.Role == RoleDrums) {
+				return a.Role == RoleDrums
+			}
+			return roleWeight(a.Role) > roleWeight(b.Role)
+		})
+		melodic := countRole(group, profiles, RoleDrums) < len(group)
+		for _, idx := range group {
+			setting := profiles[idx].Setting
+			if setting.InstrumentType == "d" && melodic {
+				setting.Steal = true
+			}
+			channels[ch] = append(channels[ch], setting)
+		}
+	}
+	return channels
+}
+
+func countRole(group []int, profiles []*TrackProfile, role string) int {
+	n := 0
+	for _, idx := range group {
+		if profiles[idx].Role == role {
+			n++
+		}
+	}
+	return n
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// noteName returns a MIDI note like "C-4"
+func noteName(note int) string {
+	return (&VirtualNote{Note: note}).String()
+}
+
+// FormatChannelMapping writes channel settings back as a mapping string.
+// It covers the fields suggestions set: type, modifiers, sample and
+// ornament, priority and steal.
+func FormatChannelMapping(channels [][]ChannelSettings) string {
+	parts := make([]string, len(channels))
+	for i, channel := range channels {
+		entries := make([]string, len(channel))
+		for j, setting := range channel {
+			entries[j] = formatEntry(setting)
+		}
+		parts[i] = strings.Join(entries, "-")
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatEntry(setting ChannelSettings) string {
+	kind := setting.InstrumentType
+	if kind == "e" {
+		kind = "me"
+	}
+	entry := fmt.Sprintf("%d%s%s", setting.MIDIChannel, kind, setting.Modifiers)
+	if setting.Ornament != 0 {
+		entry += fmt.Sprintf("[%s%s]", Params[setting.Sample], Params[setting.Ornament])
+	} else if setting.Sample != 2 {
+		entry += fmt.Sprintf("[%s]", Params[setting.Sample])
+	}
+	var options []string
+	if setting.Priority != "" {
+		options = append(options, "prio="+setting.Priority)
+	}
+	if setting.Steal {
+		options = append(options, "steal")
+	}
+	if len(options) > 0 {
+		entry += "{" + strings.Join(options, ",") + "}"
+	}
+	return entry
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// suggestNotes returns a drum track, a bass line, a chord track and a lead
+func suggestNotes() []*VirtualNote {
+	var notes []*VirtualNote
+	add := func(track, key, start, length int, gm bool) {
+		notes = append(notes, &VirtualNote{Note: key, Volume: 15, Start: start, Off: start + length, Length: length, Channel: track, GMDrums: gm})
+	}
+	for row := 0; row < 32; row += 2 {
+		add(1, 36+(row/2%2)*2, row, 1, true)
+	}
+	for row := 0; row < 32; row += 4 {
+		add(2, 36+row/8, row, 4, false)
+	}
+	for row := 0; row < 32; row += 8 {
+		for _, key := range []int{60, 64, 67} {
+			add(3, key+row/8, row, 8, false)
+		}
+	}
+	for row := 0; row < 32; row += 3 {
+		add(4, 72+row%5, row, 3, false)
+	}
+	return notes
+}
+
+func TestAnalyzeRoles(t *testing.T) {
+	profiles := NewMappingSuggester(newTestConfig(t)).Analyze(suggestNotes(), 32)
+	tests := []struct {
+		role  string
+		entry string
+	}{
+		{RoleDrums, "1du"},
+		{RoleBass, "2me"},
+		{RoleChords, "3p[3]"},
+		{RoleLead, "4m"},
+	}
+	if len(profiles) != len(tests) {
+		t.Fatalf("got %d profiles, want %d", len(profiles), len(tests))
+	}
+	for i, tt := range tests {
+		p := profiles[i]
+		if p.Role != tt.role || formatEntry(p.Setting) != tt.entry {
+			t.Errorf("track %d: %s %s, want %s %s", p.Track, p.Role, formatEntry(p.Setting), tt.role, tt.entry)
+		}
+	}
+}
+
+func TestFormatChannelMapping(t *testing.T) {
+	tests := []string{
+		"1du{steal}-2m[6]",
+		"1me{prio=low},2m[6]{prio=last}",
+		"3p[3F]-4m",
+	}
+	for _, mapping := range tests {
+		channels, err := parseChannelMapping(mapping)
+		if err != nil {
+			t.Fatalf("%q: %v", mapping, err)
+		}
+		if got := FormatChannelMapping(channels); got != mapping {
+			t.Errorf("FormatChannelMapping(%q) = %q", mapping, got)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	ms := NewMappingSuggester(newTestConfig(t, "--suggestions=2"))
+	suggestions, err := ms.Suggest(ms.Analyze(suggestNotes(), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("got %d suggestions, want 2", len(suggestions))
+	}
+	if suggestions[0].Cost > suggestions[1].Cost {
+		t.Errorf("suggestions not ranked: %v then %v", suggestions[0].Cost, suggestions[1].Cost)
+	}
+	if want := "1du{steal}-3p[3],4m,2me"; suggestions[0].Mapping != want {
+		t.Errorf("best mapping %q, want %q", suggestions[0].Mapping, want)
+	}
+	for _, suggestion := range suggestions {
+		if _, err := parseChannelMapping(suggestion.Mapping); err != nil {
+			t.Errorf("%q: %v", suggestion.Mapping, err)
+		}
+		if strings.Contains(suggestion.Mapping, "+") {
+			t.Errorf("%q: suggests the \"+\" mix option the mixer ignores", suggestion.Mapping)
+		}
+	}
+
+	// A proposed entry the parser rejects fails the search
+	profiles := ms.Analyze(suggestNotes(), 32)
+	profiles[0].Setting.InstrumentType = "x"
+	if _, err := ms.Suggest(profiles); err == nil {
+		t.Errorf("mapping with instrument type x accepted")
+	}
+}
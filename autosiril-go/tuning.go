@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults of the module header, the combination Ruby's envelope tables are
+// tuned for
+const (
+	DefaultChipFreq  = 1750000
+	DefaultNoteTable = 4
+)
+
+// ChipPreset is an AY clock selected with "--chip"
+type ChipPreset struct {
+	Name string
+	Freq int
+}
+
+// ChipPresets maps the "--chip" names to their AY clocks
+var ChipPresets = map[string]ChipPreset{
+	"zx":       {"ZX Spectrum 128", 1773400},
+	"pentagon": {"Pentagon", 1750000},
+	"atari":    {"Atari ST", 2000000},
+	"msx":      {"MSX", 1789773},
+	"cpc":      {"Amstrad CPC", 1000000},
+}
+
+// NoteTableNames are VT2's note tables selected with "--note-table"
+var NoteTableNames = []string{"ProTracker", "Sound Tracker", "ASM or PSC", "Real Sound", "Natural"}
+
+// VT2's note tables 0 to 3 (PT3NoteTable_PT_34_35, _ST, _ASM_34_35 and
+// _REAL_34_35 in its sources), C-1 to B-8, with their hand-rounded periods
+var ptNoteTable = []int{
+	0xC22, 0xB73, 0xACF, 0xA33, 0x9A1, 0x917, 0x894, 0x819, 0x7A4, 0x737, 0x6CF, 0x66D,
+	0x611, 0x5BA, 0x567, 0x519, 0x4D0, 0x48B, 0x44A, 0x40C, 0x3D2, 0x39B, 0x367, 0x337,
+	0x308, 0x2DD, 0x2B3, 0x28C, 0x268, 0x245, 0x225, 0x206, 0x1E9, 0x1CE, 0x1B4, 0x19B,
+	0x184, 0x16E, 0x15A, 0x146, 0x134, 0x123, 0x112, 0x103, 0x0F4, 0x0E7, 0x0DA, 0x0CE,
+	0x0C2, 0x0B7, 0x0AD, 0x0A3, 0x09A, 0x091, 0x089, 0x082, 0x07A, 0x073, 0x06D, 0x067,
+	0x061, 0x05C, 0x056, 0x052, 0x04D, 0x049, 0x045, 0x041, 0x03D, 0x03A, 0x036, 0x033,
+	0x031, 0x02E, 0x02B, 0x029, 0x027, 0x024, 0x022, 0x020, 0x01F, 0x01D, 0x01B, 0x01A,
+	0x018, 0x017, 0x016, 0x014, 0x013, 0x012, 0x011, 0x010, 0x00F, 0x00E, 0x00D, 0x00C,
+}
+
+var stNoteTable = []int{
+	0xEF8, 0xE10, 0xD60, 0xC80, 0xBD8, 0xB28, 0xA88, 0x9F0, 0x960, 0x8E0, 0x858, 0x7E0,
+	0x77C, 0x708, 0x6B0, 0x640, 0x5EC, 0x594, 0x544, 0x4F8, 0x4B0, 0x470, 0x42C, 0x3FD,
+	0x3BE, 0x384, 0x358, 0x320, 0x2F6, 0x2CA, 0x2A2, 0x27C, 0x258, 0x238, 0x216, 0x1F8,
+	0x1DF, 0x1C2, 0x1AC, 0x190, 0x17B, 0x165, 0x151, 0x13E, 0x12C, 0x11C, 0x10A, 0x0FC,
+	0x0EF, 0x0E1, 0x0D6, 0x0C8, 0x0BD, 0x0B2, 0x0A8, 0x09F, 0x096, 0x08E, 0x085, 0x07E,
+	0x077, 0x070, 0x06B, 0x064, 0x05E, 0x059, 0x054, 0x04F, 0x04B, 0x047, 0x042, 0x03F,
+	0x03B, 0x038, 0x035, 0x032, 0x02F, 0x02C, 0x02A, 0x027, 0x025, 0x023, 0x021, 0x01F,
+	0x01D, 0x01C, 0x01A, 0x019, 0x017, 0x016, 0x015, 0x013, 0x012, 0x011, 0x010, 0x00F,
+}
+
+var asmNoteTable = []int{
+	0xD10, 0xC55, 0xBA4, 0xAFC, 0xA5F, 0x9CA, 0x93D, 0x8B8, 0x83B, 0x7C5, 0x755, 0x6EC,
+	0x688, 0x62A, 0x5D2, 0x57E, 0x52F, 0x4E5, 0x49E, 0x45C, 0x41D, 0x3E2, 0x3AB, 0x376,
+	0x344, 0x315, 0x2E9, 0x2BF, 0x298, 0x272, 0x24F, 0x22E, 0x20F, 0x1F1, 0x1D5, 0x1BB,
+	0x1A2, 0x18B, 0x174, 0x160, 0x14C, 0x139, 0x128, 0x117, 0x107, 0x0F9, 0x0EB, 0x0DD,
+	0x0D1, 0x0C5, 0x0BA, 0x0B0, 0x0A6, 0x09D, 0x094, 0x08C, 0x084, 0x07C, 0x075, 0x06F,
+	0x069, 0x063, 0x05D, 0x058, 0x053, 0x04E, 0x04A, 0x046, 0x042, 0x03E, 0x03B, 0x037,
+	0x034, 0x031, 0x02F, 0x02C, 0x029, 0x027, 0x025, 0x023, 0x021, 0x01F, 0x01D, 0x01C,
+	0x01A, 0x019, 0x017, 0x016, 0x015, 0x014, 0x012, 0x011, 0x010, 0x00F, 0x00E, 0x00D,
+}
+
+var realNoteTable = []int{
+	0xCDA, 0xC22, 0xB73, 0xACF, 0xA33, 0x9A1, 0x917, 0x894, 0x819, 0x7A4, 0x737, 0x6CF,
+	0x66D, 0x611, 0x5BA, 0x567, 0x519, 0x4D0, 0x48B, 0x44A, 0x40C, 0x3D2, 0x39B, 0x367,
+	0x337, 0x308, 0x2DD, 0x2B3, 0x28C, 0x268, 0x245, 0x225, 0x206, 0x1E9, 0x1CE, 0x1B4,
+	0x19B, 0x184, 0x16E, 0x15A, 0x146, 0x134, 0x123, 0x112, 0x103, 0x0F4, 0x0E7, 0x0DA,
+	0x0CE, 0x0C2, 0x0B7, 0x0AD, 0x0A3, 0x09A, 0x091, 0x089, 0x082, 0x07A, 0x073, 0x06D,
+	0x067, 0x061, 0x05C, 0x056, 0x052, 0x04D, 0x049, 0x045, 0x041, 0x03D, 0x03A, 0x036,
+	0x033, 0x031, 0x02E, 0x02B, 0x029, 0x027, 0x024, 0x022, 0x020, 0x01F, 0x01D, 0x01B,
+	0x01A, 0x018, 0x017, 0x016, 0x014, 0x013, 0x012, 0x011, 0x010, 0x00F, 0x00E, 0x00D,
+}
+
+// naturalNoteTable is VT2's note table 4, C-1 to B-8
+var naturalNoteTable = []int{
+	2880, 2700, 2560, 2400, 2304, 2160, 2025, 1920, 1800, 1728, 1620, 1536,
+	1440, 1350, 1280, 1200, 1152, 1080, 1013, 960, 900, 864, 810, 768,
+	720, 675, 640, 600, 576, 540, 506, 480, 450, 432, 405, 384,
+	360, 338, 320, 300, 288, 270, 253, 240, 225, 216, 203, 192,
+	180, 169, 160, 150, 144, 135, 127, 120, 113, 108, 101, 96,
+	90, 84, 80, 75, 72, 68, 63, 60, 56, 54, 51, 48,
+	45, 42, 40, 38, 36, 34, 32, 30, 28, 27, 25, 24,
+	23, 21, 20, 19, 18, 17, 16, 15, 14, 14, 13, 12,
+}
+
+// noteTables are VT2's note tables by number
+var noteTables = [][]int{ptNoteTable, stNoteTable, asmNoteTable, realNoteTable, naturalNoteTable}
+
+// Envelope forms the tuning chooses from, with the chip clocks per envelope
+// period unit of one cycle
+const (
+	EnvFormSaw      = 12 // repeating ramp up, 256 clocks per period unit
+	EnvFormTriangle = 10 // repeating down and up, 512 clocks per period unit
+)
+
+// noteTablePeriods returns the 96 tone periods of a VT2 note table
+func noteTablePeriods(table int) []int {
+	return noteTables[table]
+}
+
+// EnvelopeTuning is the envelope note (as an offset) and form played for
+// every MIDI note on "e" channels, with the detune in cents
+type EnvelopeTuning struct {
+	config    *AutosirilConfig
+	periods   []int
+	Offsets   []int
+	Forms     []int
+	Cents     []float64 // envelope against the tone
+	ToneCents []float64 // tone against equal temperament with A-4 at 440 Hz
+}
+
+// NewEnvelopeTuning computes the tuning for the chip clock and note table.
+// Without "--chip" and "--note-table" it keeps Ruby's hand-tuned tables and
+// only measures their detune.
+func NewEnvelopeTuning(config *AutosirilConfig) *EnvelopeTuning {
+	et := &EnvelopeTuning{
+		config:    config,
+		periods:   noteTablePeriods(config.NoteTable),
+		Offsets:   make([]int, len(EnvOffsets)),
+		Forms:     make([]int, len(EnvForms)),
+		Cents:     make([]float64, len(EnvOffsets)),
+		ToneCents: make([]float64, len(EnvOffsets)),
+	}
+	for note := range et.Offsets {
+		tone := vtNoteIndex(note)
+		toneHz := float64(config.ChipFreq) / float64(16*et.periods[tone])
+		et.ToneCents[note] = cents(toneHz / (440 * math.Pow(2, float64(tone+24-69)/12)))
+		if config.ComputedTuning {
+			et.Offsets[note], et.Forms[note] = et.bestEnvelope(note)
+		} else {
+			et.Offsets[note], et.Forms[note] = EnvOffsets[note], EnvForms[note]
+		}
+		et.Cents[note] = et.detune(note, note+et.Offsets[note], et.Forms[note])
+	}
+	return et
+}
+
+// bestEnvelope picks the envelope note and form with the least detune
+// against the tone. The envelope may sound from two octaves below to one
+// octave above the tone; within a cent the octave closest to the tone, then
+// the sawtooth wins.
+func (et *EnvelopeTuning) bestEnvelope(note int) (int, int) {
+	bestOffset, bestForm := 0, EnvFormSaw
+	bestDetune, bestOctave := math.Inf(1), 0
+	for _, octave := range []int{0, -1, 1, -2} {
+		for _, form := range []int{EnvFormSaw, EnvFormTriangle} {
+			// The envelope note sounding at this octave of the tone
+			offset := 12 * octave
+			if form == EnvFormTriangle {
+				offset += 12
+			}
+			envNote := note + offset
+			if envNote < 24 || envNote > 119 || et.envelopePeriod(envNote) < 1 {
+				continue
+			}
+			detune := math.Abs(et.detune(note, envNote, form))
+			if detune < bestDetune-1 || (detune < bestDetune && abs(octave) <= abs(bestOctave)) {
+				bestOffset, bestForm, bestDetune, bestOctave = offset, form, detune, octave
+			}
+		}
+	}
+	return bestOffset, bestForm
+}
+
+// envelopePeriod is the period VT2 loads for an envelope note: the tone
+// period of the note divided by 16
+func (et *EnvelopeTuning) envelopePeriod(envNote int) int {
+	return (et.periods[vtNoteIndex(envNote)] + 8) / 16
+}
+
+// detune returns the cents between the envelope and the nearest octave of
+// the tone
+func (et *EnvelopeTuning) detune(note, envNote, form int) float64 {
+	period := et.envelopePeriod(envNote)
+	if period < 1 {
+		return 0
+	}
+	clocks := 256
+	if form == EnvFormTriangle {
+		clocks = 512
+	}
+	c := cents(float64(16*et.periods[vtNoteIndex(note)]) / float64(clocks*period))
+	return c - 1200*math.Round(c/1200)
+}
+
+// describe returns "Pentagon 1750000 Hz, note table 4 (Natural), Ruby's envelope tables"
+func (et *EnvelopeTuning) describe() string {
+	chip := fmt.Sprintf("%d Hz", et.config.ChipFreq)
+	if preset, ok := ChipPresets[et.config.Chip]; ok {
+		chip = fmt.Sprintf("%s %d Hz", preset.Name, preset.Freq)
+	}
+	tuning := "computed envelope tuning"
+	if !et.config.ComputedTuning {
+		tuning = "Ruby's envelope tables"
+	}
+	return fmt.Sprintf("%s, note table %d (%s), %s", chip, et.config.NoteTable, NoteTableNames[et.config.NoteTable], tuning)
+}
+
+// Report prints the detune of the envelope notes the channels play
+func (et *EnvelopeTuning) Report(channels [][]*VortexNote) {
+	used := make(map[int]bool)
+	for _, channel := range channels {
+		for _, cell := range channel {
+			if cell.InstrumentKind == "e" && cell.Type == "s" && cell.Envelope != EnvelopeOff && cell.Note < len(et.Cents) {
+				used[cell.Note] = true
+			}
+		}
+	}
+	if len(used) == 0 {
+		return
+	}
+	var notes []int
+	for note := range used {
+		notes = append(notes, note)
+	}
+	sort.Ints(notes)
+	worst, sum := notes[0], 0.0
+	for _, note := range notes {
+		sum += math.Abs(et.Cents[note])
+		if math.Abs(et.Cents[note]) > math.Abs(et.Cents[worst]) {
+			worst = note
+		}
+	}
+	et.config.logf("envelope tuning: %s: %d envelope notes, mean detune %.1f cents, worst %+.1f cents (%s)\n",
+		et.describe(), len(notes), sum/float64(len(notes)), et.Cents[worst], vtNoteName(vtNoteIndex(worst)))
+}
+
+// Save writes the tone, envelope and detune of every VT2 note
+func (et *EnvelopeTuning) Save(filename string) error {
+	var output strings.Builder
+	fmt.Fprintf(&output, "%s\n", et.describe())
+	fmt.Fprintf(&output, "note  tone period  cents vs A-4=440  envelope  form  period  cents vs tone\n")
+	for tone := range et.periods {
+		note := tone + 24
+		envNote := note + et.Offsets[note]
+		fmt.Fprintf(&output, "%-4s  %11d  %+16.1f  %-8s  %4d  %6d  %+13.1f\n",
+			vtNoteName(tone), et.periods[tone], et.ToneCents[note],
+			vtNoteName(vtNoteIndex(envNote)), et.Forms[note], et.envelopePeriod(envNote), et.Cents[note])
+	}
+	return os.WriteFile(filename, []byte(output.String()), 0644)
+}
+
+// vtNoteIndex returns the VT2 note (0 = C-1) a MIDI note is written as
+func vtNoteIndex(note int) int {
+	tn := NewTimelineNote(note, 0, "s")
+	return clamp((tn.Octave-1)*12+tn.Pitch, 0, 95)
+}
+
+func vtNoteName(index int) string {
+	return fmt.Sprintf("%s%d", Pitches[index%12], index/12+1)
+}
+
+func cents(ratio float64) float64 {
+	return 1200 * math.Log2(ratio)
+}
+
+// parseChip parses "--chip=pentagon" or a clock in Hz
+func parseChip(value string) (string, int, error) {
+	if preset, ok := ChipPresets[strings.ToLower(value)]; ok {
+		return strings.ToLower(value), preset.Freq, nil
+	}
+	if freq, err := strconv.Atoi(value); err == nil && freq >= 1000000 && freq <= 4000000 {
+		return "", freq, nil
+	}
+	return "", 0, fmt.Errorf("unknown chip '%s' (expected zx, pentagon, atari, msx, cpc or a clock in Hz from 1000000 to 4000000)", value)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseChip(t *testing.T) {
+	tests := []struct {
+		value string
+		chip  string
+		freq  int
+		err   bool
+	}{
+		{"pentagon", "pentagon", 1750000, false},
+		{"ZX", "zx", 1773400, false},
+		{"cpc", "cpc", 1000000, false},
+		{"1789773", "", 1789773, false},
+		{"999999", "", 0, true},
+		{"c64", "", 0, true},
+	}
+	for _, tt := range tests {
+		chip, freq, err := parseChip(tt.value)
+		if (err != nil) != tt.err || chip != tt.chip || freq != tt.freq {
+			t.Errorf("parseChip(%q) = %q %d %v, want %q %d error %v", tt.value, chip, freq, err, tt.chip, tt.freq, tt.err)
+		}
+	}
+}
+
+func TestNoteTablePeriods(t *testing.T) {
+	for table := range NoteTableNames {
+		periods := noteTablePeriods(table)
+		if len(periods) != 96 {
+			t.Errorf("table %d: %d periods, want 96", table, len(periods))
+			continue
+		}
+		for i := 1; i < len(periods); i++ {
+			if periods[i] > periods[i-1] {
+				t.Errorf("table %d: period of note %d rises from %d to %d", table, i, periods[i-1], periods[i])
+			}
+		}
+	}
+	for table, first := range []int{0xC22, 0xEF8, 0xD10, 0xCDA, 2880} {
+		if got := noteTablePeriods(table)[0]; got != first {
+			t.Errorf("table %d starts at %#x, want %#x", table, got, first)
+		}
+	}
+	// REAL is PT a semitone lower
+	for i := 1; i < 96; i++ {
+		if noteTablePeriods(3)[i] != noteTablePeriods(0)[i-1] {
+			t.Errorf("REAL note %d is %#x, want PT note %d %#x", i, noteTablePeriods(3)[i], i-1, noteTablePeriods(0)[i-1])
+		}
+	}
+}
+
+func TestEnvelopeTuning(t *testing.T) {
+	tests := []struct {
+		args     []string
+		computed bool
+		worst    float64 // largest detune in cents from C-3 to B-5
+	}{
+		{nil, false, 0},
+		{[]string{"--chip=zx"}, true, 30},
+		{[]string{"--chip=atari", "--note-table=0"}, true, 30},
+		{[]string{"--note-table=2"}, true, 30},
+	}
+	for _, tt := range tests {
+		config := newTestConfig(t, tt.args...)
+		if config.ComputedTuning != tt.computed {
+			t.Errorf("%v: computed tuning %v, want %v", tt.args, config.ComputedTuning, tt.computed)
+		}
+		et := NewEnvelopeTuning(config)
+		for note := 48; note < 84; note++ {
+			if !tt.computed {
+				if et.Offsets[note] != EnvOffsets[note] || et.Forms[note] != EnvForms[note] {
+					t.Errorf("%v: note %d changed the Ruby tables", tt.args, note)
+				}
+			} else if math.Abs(et.Cents[note]) > tt.worst {
+				t.Errorf("%v: note %d detuned %.1f cents", tt.args, note, et.Cents[note])
+			}
+		}
+	}
+}
+
+func TestTunedEnvelopeNotes(t *testing.T) {
+	rubyOffsets := append([]int{}, EnvOffsets...)
+	rubyForms := append([]int{}, EnvForms...)
+	channels, err := parseChannelMapping("1me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := newTestConfig(t, "--chip=atari", "--note-table=0")
+	config.Tuning = NewEnvelopeTuning(config)
+
+	for note := 36; note < 96; note++ {
+		notes := []*VirtualNote{{Note: note, Volume: 15, Start: 0, Off: 2, Channel: 1}}
+		timelines, ornamentGen, err := NewPolyphonicProcessor(config).FlattenNotes(notes, 4, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cell := NewChannelMixer(config, ornamentGen).MixChannels(timelines, channels, nil)[0][0]
+		envNote := note + config.Tuning.Offsets[note]
+		if cell.Envelope != config.Tuning.Forms[note] || cell.EnvelopePitch != envNote%12 || cell.EnvelopeOctave != cell.noteToOctave(envNote) {
+			t.Errorf("note %d: envelope %d %d/%d, want form %d note %d", note, cell.Envelope, cell.EnvelopePitch, cell.EnvelopeOctave, config.Tuning.Forms[note], envNote)
+		}
+	}
+	for note := range rubyOffsets {
+		if EnvOffsets[note] != rubyOffsets[note] || EnvForms[note] != rubyForms[note] {
+			t.Fatalf("the tuning changed Ruby's tables at note %d", note)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"strconv"
+	"strings"
 )
 
 // VirtualNote represents a MIDI note event with tracker timing
@@ -14,6 +18,14 @@ type VirtualNote struct {
 	Length   int
 	Channel  int
 	Settings string
+	Velocity int // original MIDI velocity, Volume stays 15 like Ruby
+	Tick     int // MIDI tick of the note on
+	GMDrums  bool // played on General MIDI percussion channel 10
+	// GraceNotes are merged sub-row notes played as ornament steps before Note
+	GraceNotes []int
+	// Merged are source notes played as part of this one (merged graces,
+	// collapsed arpeggio runs)
+	Merged []*VirtualNote
 }
 
 // String returns note display format
@@ -44,13 +56,16 @@ func (v *VirtualNote) noteToOctave() int {
 type TimelineNote struct {
 	Note           int
 	Volume         int
-	Type           string // 's'=start, 'r'=release, 'c'=continue, '.'=empty
+	Type           string // 's'=start, 'r'=release, 'c'=continue, 'o'=ornament change, '.'=empty
 	Pitch          int
 	Octave         int
 	InstrumentKind string
 	Channel        int
 	Settings       string
 	ChordNotes     []int // For polyphonic: all simultaneous notes for ornament generation
+	GraceNotes     []int // For monophonic starts: merged grace notes (see grace.go)
+	Echo           bool  // delayed copy written by ApplyEcho
+	Sources        []*VirtualNote // source notes starting in this cell, see LostNoteReport
 }
 
 func NewTimelineNote(note, volume int, noteType string) *TimelineNote {
@@ -95,7 +110,7 @@ func (tn *TimelineNote) String() string {
 type VortexNote struct {
 	Note            int
 	Volume          int
-	Type            string // 's'=start, 'r'=release, 'c'=continue, '.'=empty
+	Type            string // 's'=start, 'r'=release, 'c'=continue, 'o'=ornament change, '.'=empty
 	Pitch           int
 	Octave          int
 	InstrumentKind  string
@@ -106,6 +121,9 @@ type VortexNote struct {
 	EnvelopeOctave  int
 	Channel         int
 	Settings        string
+	Sources         []*VirtualNote // source notes played by this cell
+	EnvPriority     int            // envelope arbitration priority of "e" notes
+	EnvPeriod       bool           // writes its envelope period on its row
 }
 
 func NewVortexNote(timelineNote *TimelineNote) *VortexNote {
@@ -118,23 +136,29 @@ func NewVortexNote(timelineNote *TimelineNote) *VortexNote {
 		InstrumentKind: timelineNote.InstrumentKind,
 		Channel:        timelineNote.Channel,
 		Settings:       timelineNote.Settings,
+		Sources:        timelineNote.Sources,
 		Sample:         2, // Default sample is 2 to match Ruby
 		Envelope:       0,
 		Ornament:       0,
 	}
 	
+	vn.setEnvelope(EnvOffsets, EnvForms)
+	return vn
+}
+
+// setEnvelope sets the envelope note and form played with the note from the
+// given offset and form tables
+func (vn *VortexNote) setEnvelope(offsets, forms []int) {
 	// Calculate envelope pitch and octave only for actual notes (not empty notes)
-	if vn.Type != "." && vn.Note >= 0 && vn.Note < len(EnvOffsets) {
-		envelopeNote := vn.Note + EnvOffsets[vn.Note]
+	if vn.Type != "." && vn.Note >= 0 && vn.Note < len(offsets) {
+		envelopeNote := vn.Note + offsets[vn.Note]
 		vn.EnvelopePitch = envelopeNote % 12
 		vn.EnvelopeOctave = vn.noteToOctave(envelopeNote)
 		
-		if vn.Note < len(EnvForms) {
-			vn.Envelope = EnvForms[vn.Note]
+		if vn.Note < len(forms) {
+			vn.Envelope = forms[vn.Note]
 		}
 	}
-	
-	return vn
 }
 
 func (vn *VortexNote) noteToOctave(note int) int {
@@ -158,7 +182,7 @@ func (vn *VortexNote) String() string {
 	switch vn.Type {
 	case "r":
 		return "R--"
-	case ".":
+	case ".", "o":
 		return "---"
 	default:
 		return fmt.Sprintf("%s%d", Pitches[vn.Pitch], vn.Octave)
@@ -166,14 +190,14 @@ func (vn *VortexNote) String() string {
 }
 
 func (vn *VortexNote) EnvelopeNoteDisplay() string {
-	if !vn.EnvelopeActive() {
+	if !vn.EnvPeriod {
 		return "...."
 	}
 	return fmt.Sprintf(" %s%d", Pitches[vn.EnvelopePitch], vn.EnvelopeOctave)
 }
 
 func (vn *VortexNote) EnvelopeActive() bool {
-	return vn.InstrumentKind == "e" && vn.Type != "r" && vn.Type != "." && vn.Envelope >= 1 && vn.Envelope <= 14
+	return vn.InstrumentKind == "e" && vn.Type != "r" && vn.Type != "." && vn.Type != "o" && vn.Envelope >= 1 && vn.Envelope <= 14
 }
 
 // ChannelSettings represents parsed channel configuration
@@ -184,6 +208,33 @@ type ChannelSettings struct {
 	Sample         int
 	Ornament       int
 	MixOption      string // +, -
+	Priority       string // monophonic note priority, see PriorityHigh etc.
+	ResumeHeld     bool   // retrigger a held note when a shorter overlapping note ends
+	DrumPriority   []string // drum class order, nil = DefaultDrumPriority
+	DrumSpill      int      // rows a losing drum hit may move forward, 0 = drop
+	Voicing        string   // chord canonicalization for "p" channels, see VoicingRoot
+	Outliers       string   // chord tones beyond MaxOffset: OutliersDrop or OutliersFold
+	Arp            string   // arpeggio shape of chord ornaments, see ArpUp
+	ArpRate        int      // frames per arpeggio step, 0 = OrnRepeat
+	ArpLoop        int      // arpeggio step the ornament loops back to
+	ArpSeed        int64    // seed for ArpRandom
+	Collapse       bool     // turn fast MIDI arpeggios into one note with an ornament
+	Attacks        map[string][]int // one-shot attack offsets by drum class, "" = every note
+	Echo           bool  // an echo option turns echo on without --echo
+	EchoDelays     []int // echo tap delays in rows, nil = PerDelay and PerDelay2
+	EchoDecay      []int // percent per tap, or one level per tap; nil = DefaultEchoDecay
+	EchoCutoff     int   // echoes quieter than this volume are not written
+	EchoSample     int   // sample of echoes, 0 = the channel's
+	EchoOrnament   int   // ornament of echoes, -1 = the note's
+	PingPong       int   // AY channel receiving every other echo tap, -1 = none
+	EchoPriority   string // which rows echoes may replace, see EchoPrioRelease
+	EchoSustain    int    // with EchoPrioSustain, rows a note keeps before echoes cut it
+	Steal          bool   // drums take over the AY channel and the melody resumes after them
+	StealRows      int    // rows a stealing hit holds the channel, 0 = its sample's length
+	Mux            string // share the AY channel by alternating colliding voices, see MuxRows
+	MuxDuty        [2]int // rows (or frames) of the earlier voice and of this entry, zero = 1.1
+	Pool           string // play the notes on any idle AY channel, see PoolStealAge
+	EnvPriority    int    // "e" entries: higher wins the hardware envelope, ties go to the newer note
 }
 
 // AutosirilConfig holds all configuration parameters
@@ -199,10 +250,30 @@ type AutosirilConfig struct {
 	MaxOffset           int
 	DiatonicTranspose   int
 	RealKey             int
+	GracePolicy         string // --grace: drop, extend or merge
+	ArpMinNotes         int    // --arp-min-notes: shortest run collapsed into an ornament
+	ArpMaxLen           int    // --arp-max-len: longest note (rows) counted as part of a run
+	ArpMaxTones         int    // --arp-max-tones: most distinct pitches in a run
+	OrnamentsFile       string // --ornaments: persisted ornament table to keep ids stable
+	SaveOrnamentsFile   string // --save-ornaments: where to write the final ornament table
+	LostNotesFile       string // --lost-notes: where to write the lost-note report (.json or text)
+	Suggestions         int    // --suggestions: how many mappings "suggest" and "optimize" print
+	MaxCandidates       int    // --max-candidates: mappings "optimize" converts per stage
+	TrackWeights        map[int]float64 // --weights: cost of a lost note row per track, default 1
+	Chip                string // --chip: name of the ChipPresets entry, "" for a clock or the default
+	ChipFreq            int    // --chip: AY clock in Hz written as ChipFreq
+	NoteTable           int    // --note-table: VT2 note table 0-4
+	ComputedTuning      bool   // envelope offsets and forms computed for ChipFreq and NoteTable
+	TuningReportFile    string // --tuning-report: where to write the per-note detune table
+	Echo                bool   // --echo: Ruby's echo on every entry without the "u" modifier
+	Tuning              *EnvelopeTuning // envelope notes and forms of "e" entries, Ruby's tables when nil
 	ParsedChannels      [][]ChannelSettings
+	Log                 io.Writer // progress output of the conversion stages, os.Stdout by default
 }
 
-func NewAutosirilConfig(args []string) *AutosirilConfig {
+// NewAutosirilConfig builds the configuration from positional arguments
+// (Ruby's ARGV order) and "--name=value" flags, which may appear anywhere
+func NewAutosirilConfig(args []string) (*AutosirilConfig, error) {
 	config := &AutosirilConfig{
 		InputFile:         "./test/tottoro_example.mid",
 		ChannelMapping:    "1d-2me-3p,4m[uf]-5m[2]+,5m[6]-6me[2]+-3p[3]+-2mew+",
@@ -215,6 +286,19 @@ func NewAutosirilConfig(args []string) *AutosirilConfig {
 		MaxOffset:         12,
 		DiatonicTranspose: 0,
 		RealKey:           13,
+		GracePolicy:       GraceDrop,
+		ArpMinNotes:       4,
+		ArpMaxLen:         1,
+		ArpMaxTones:       4,
+		Suggestions:       3,
+		ChipFreq:          DefaultChipFreq,
+		NoteTable:         DefaultNoteTable,
+		Log:               os.Stdout,
+	}
+
+	args, err := config.parseFlags(args)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Parse command line arguments
@@ -252,7 +336,105 @@ func NewAutosirilConfig(args []string) *AutosirilConfig {
 		config.RealKey = parseInt(args[10], config.RealKey)
 	}
 	
-	return config
+	return config, nil
+}
+
+// parseFlags applies "--name=value" flags and returns the positional arguments
+func (config *AutosirilConfig) parseFlags(args []string) ([]string, error) {
+	var positional []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		switch name {
+		case "grace":
+			switch value {
+			case GraceDrop, GraceExtend, GraceMerge:
+				config.GracePolicy = value
+			default:
+				return nil, fmt.Errorf("unknown grace policy '%s' (expected drop, extend or merge)", value)
+			}
+		case "ornaments", "save-ornaments":
+			if value == "" {
+				return nil, fmt.Errorf("flag '--%s' expects a file name", name)
+			}
+			if name == "ornaments" {
+				config.OrnamentsFile = value
+			} else {
+				config.SaveOrnamentsFile = value
+			}
+		case "lost-notes":
+			if value == "" {
+				return nil, fmt.Errorf("flag '--%s' expects a file name", name)
+			}
+			config.LostNotesFile = value
+		case "suggestions":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("flag '--%s' expects a positive number, got '%s'", name, value)
+			}
+			config.Suggestions = n
+		case "max-candidates":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("flag '--%s' expects a positive number, got '%s'", name, value)
+			}
+			config.MaxCandidates = n
+		case "weights":
+			weights, err := parseTrackWeights(value)
+			if err != nil {
+				return nil, err
+			}
+			config.TrackWeights = weights
+		case "chip":
+			chip, freq, err := parseChip(value)
+			if err != nil {
+				return nil, err
+			}
+			config.Chip, config.ChipFreq = chip, freq
+			config.ComputedTuning = true
+		case "note-table":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n >= len(NoteTableNames) {
+				return nil, fmt.Errorf("flag '--%s' expects a VT2 note table from 0 to %d, got '%s'", name, len(NoteTableNames)-1, value)
+			}
+			config.NoteTable = n
+			config.ComputedTuning = true
+		case "echo":
+			if value != "" {
+				return nil, fmt.Errorf("flag '--%s' takes no value", name)
+			}
+			config.Echo = true
+		case "tuning-report":
+			if value == "" {
+				return nil, fmt.Errorf("flag '--%s' expects a file name", name)
+			}
+			config.TuningReportFile = value
+		case "arp-min-notes", "arp-max-len", "arp-max-tones":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("flag '--%s' expects a positive number, got '%s'", name, value)
+			}
+			switch name {
+			case "arp-min-notes":
+				config.ArpMinNotes = n
+			case "arp-max-len":
+				config.ArpMaxLen = n
+			case "arp-max-tones":
+				config.ArpMaxTones = n
+			}
+		default:
+			return nil, fmt.Errorf("unknown flag '--%s'", name)
+		}
+	}
+	return positional, nil
+}
+
+// logf prints progress of a conversion stage to the configured log
+func (config *AutosirilConfig) logf(format string, args ...interface{}) {
+	fmt.Fprintf(config.Log, format, args...)
 }
 
 func parseInt(s string, defaultVal int) int {
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// newTestConfig builds a config from command line arguments or fails the test
+func newTestConfig(t *testing.T, args ...string) *AutosirilConfig {
+	t.Helper()
+	config, err := NewAutosirilConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestNewAutosirilConfigFlags(t *testing.T) {
+	tests := []struct {
+		args  []string
+		input string
+		grace string
+		err   string
+	}{
+		{nil, "./test/tottoro_example.mid", GraceDrop, ""},
+		{[]string{"song.mid", "--grace=merge", "1m"}, "song.mid", GraceMerge, ""},
+		{[]string{"--grace=extend", "song.mid"}, "song.mid", GraceExtend, ""},
+		{[]string{"song.mid", "--grace=keep"}, "", "", "unknown grace policy 'keep' (expected drop, extend or merge)"},
+		{[]string{"song.mid", "--fast"}, "", "", "unknown flag '--fast'"},
+		{[]string{"song.mid", "--ornaments="}, "", "", "flag '--ornaments' expects a file name"},
+		{[]string{"song.mid", "--arp-max-len=0"}, "", "", "flag '--arp-max-len' expects a positive number, got '0'"},
+	}
+	for _, tt := range tests {
+		config, err := NewAutosirilConfig(tt.args)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: got error %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.args, err)
+			continue
+		}
+		if config.InputFile != tt.input || config.GracePolicy != tt.grace {
+			t.Errorf("%v: got input %q grace %q, want %q %q", tt.args, config.InputFile, config.GracePolicy, tt.input, tt.grace)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Voice stealing policies selected with "{pool=...}"
+const (
+	PoolStealAge    = "age"    // a new note takes the voice of the oldest note (default)
+	PoolStealVolume = "volume" // a new note takes the voice of a quieter (lower velocity) note
+)
+
+// PoolNote is a note of a "{pool}" entry waiting for a free AY channel
+type PoolNote struct {
+	Note     int
+	Start    int
+	End      int
+	Velocity int
+	Home     int // AY channel of the entry in the mapping
+	Setting  *ChannelSettings
+	Source   *VirtualNote
+	folded   bool // played in the ornament of another pooled voice
+}
+
+// CollectPool returns the notes of all "{pool}" entries, which the mixer
+// spreads over the AY channels instead of flattening them into one
+func (pp *PolyphonicProcessor) CollectPool(virtualNotes []*VirtualNote, channelSettings [][]ChannelSettings) []*PoolNote {
+	var pool []*PoolNote
+	for ayIdx, ayChannel := range channelSettings {
+		for i := range ayChannel {
+			setting := &ayChannel[i]
+			if setting.Pool == "" {
+				continue
+			}
+			for _, vNote := range virtualNotes {
+				if vNote.Channel == setting.MIDIChannel && vNote.Length > 0 {
+					pool = append(pool, &PoolNote{
+						Note:     vNote.Note,
+						Start:    vNote.Start + pp.config.SkipLines,
+						End:      vNote.Off + pp.config.SkipLines,
+						Velocity: vNote.Velocity,
+						Home:     ayIdx,
+						Setting:  setting,
+						Source:   vNote,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		if pool[i].Start != pool[j].Start {
+			return pool[i].Start < pool[j].Start
+		}
+		return pool[i].Note > pool[j].Note
+	})
+	return pool
+}
+
+// poolVoice is a pooled note playing on an AY channel
+type poolVoice struct {
+	note     *PoolNote
+	cell     *VortexNote // the start of the note on its channel
+	since    int
+	ornament int // ornament folding overflow notes of the same entry
+}
+
+// poolStats counts where pooled notes ended up
+type poolStats struct {
+	home, other, stolen, displaced, folded, dropped int
+}
+
+// AllocateVoices plays the pooled notes on whichever AY channel is idle,
+// row by row like a synth voice allocator. A note prefers its home channel,
+// a new note may steal a pooled voice, and notes without a voice are folded
+// into the ornament of the pooled voice on their home channel.
+func (cm *ChannelMixer) AllocateVoices(channels [][]*VortexNote, pool []*PoolNote) {
+	if len(pool) == 0 {
+		return
+	}
+	cm.config.logf("--- allocating pooled voices ---\n")
+
+	rows := len(channels[0])
+	busy := make([][]bool, len(channels))
+	for ch, channel := range channels {
+		busy[ch] = make([]bool, rows)
+		var state melodyState
+		for pos, cell := range channel {
+			state.update(pos, cell)
+			busy[ch][pos] = state.note != nil && cell.Type != "r"
+		}
+	}
+
+	voices := make([]*poolVoice, len(channels))
+	var waiting []*PoolNote // sounding notes without a voice
+	var stats poolStats
+	next := 0
+	for pos := 0; pos < rows; pos++ {
+		// Finished notes release their channel
+		for ch, voice := range voices {
+			if voice != nil && pos >= voice.note.End {
+				if !busy[ch][pos] && channels[ch][pos].Type == "." {
+					channels[ch][pos] = NewVortexNote(NewTimelineNote(0, 0, "r"))
+				}
+				voices[ch] = nil
+			}
+		}
+		waiting = stillSounding(waiting, pos)
+
+		// Static entries win: a voice whose channel gets busy has to move
+		for ch, voice := range voices {
+			if voice != nil && busy[ch][pos] {
+				voices[ch] = nil
+				waiting = append(waiting, voice.note)
+				stats.displaced++
+			}
+		}
+
+		var starting []*PoolNote
+		for ; next < len(pool) && pool[next].Start <= pos; next++ {
+			if pool[next].Start == pos {
+				starting = append(starting, pool[next])
+			}
+		}
+
+		pending := append(append([]*PoolNote{}, waiting...), starting...)
+		for _, note := range pending {
+			ch := freeVoiceChannel(voices, busy, pos, note.Home)
+			isNew := note.Start == pos
+			if ch < 0 && isNew {
+				ch = stealVoice(voices, note)
+				if ch >= 0 {
+					waiting = append(waiting, voices[ch].note)
+					stats.stolen++
+				}
+			}
+			if ch < 0 {
+				if isNew {
+					waiting = append(waiting, note)
+				}
+				continue
+			}
+			waiting = removePoolNote(waiting, note)
+			cell := cm.poolNote(note, 0)
+			voices[ch] = &poolVoice{note: note, cell: cell, since: pos}
+			channels[ch][pos] = cell
+			if isNew && ch == note.Home {
+				stats.home++
+			} else if isNew {
+				stats.other++
+			}
+		}
+
+		cm.foldOverflow(channels, voices, waiting, pos, &stats)
+	}
+
+	cm.config.logf("voice pool: %d notes, %d on their home channel, %d on other channels, %d voices stolen, %d moved for static entries, %d rows folded into ornaments, %d notes dropped\n",
+		len(pool), stats.home, stats.other, stats.stolen, stats.displaced, stats.folded, stats.dropped)
+}
+
+// foldOverflow adds the waiting notes to the ornament of the pooled voice on
+// their home channel. Notes that do not fit are dropped once they start.
+func (cm *ChannelMixer) foldOverflow(channels [][]*VortexNote, voices []*poolVoice, waiting []*PoolNote, pos int, stats *poolStats) {
+	for ch, voice := range voices {
+		if voice == nil {
+			continue
+		}
+		offsets := []int{0}
+		var folded []*PoolNote
+		for _, note := range waiting {
+			offset := note.Note - voice.note.Note
+			if note.Home == ch && note.Setting == voice.note.Setting && abs(offset) <= cm.config.MaxOffset {
+				offsets = append(offsets, offset)
+				folded = append(folded, note)
+			}
+		}
+
+		ornament := 0
+		if len(offsets) > 1 {
+			sort.Ints(offsets)
+			key := cm.ornaments.arpeggioString(offsets, voice.note.Setting)
+			if id, ok := cm.ornaments.AddOrnament(key); ok {
+				ornament = id
+				stats.folded++
+				for _, note := range folded {
+					if !note.folded {
+						note.folded = true
+						voice.cell.Sources = append(voice.cell.Sources, note.Source)
+					}
+				}
+			}
+		}
+		if ornament == voice.ornament {
+			continue
+		}
+		voice.ornament = ornament
+		if channels[ch][pos].Type == "s" {
+			channels[ch][pos].Ornament = ornament
+		} else {
+			change := cm.poolNote(voice.note, ornament)
+			change.Type = "o"
+			channels[ch][pos] = change
+		}
+	}
+
+	for _, note := range waiting {
+		if note.Start == pos && !voiceFolds(voices, note, cm.config.MaxOffset) {
+			stats.dropped++
+		}
+	}
+}
+
+// voiceFolds reports whether a waiting note is folded into a voice
+func voiceFolds(voices []*poolVoice, note *PoolNote, maxOffset int) bool {
+	voice := voices[note.Home]
+	return voice != nil && voice.ornament != 0 && voice.note.Setting == note.Setting &&
+		abs(note.Note-voice.note.Note) <= maxOffset
+}
+
+// poolNote renders a pooled note with its entry's sample and an ornament
+func (cm *ChannelMixer) poolNote(note *PoolNote, ornament int) *VortexNote {
+	timelineNote := NewTimelineNote(note.Note, 15, "s")
+	timelineNote.InstrumentKind = note.Setting.InstrumentType
+	timelineNote.Channel = note.Source.Channel
+	timelineNote.Sources = []*VirtualNote{note.Source}
+	if ornament != 0 {
+		timelineNote.Settings = fmt.Sprintf("ornament:%d", ornament)
+	}
+	return cm.entryNote(timelineNote, note.Setting)
+}
+
+// freeVoiceChannel returns an idle AY channel, the home channel first, or -1
+func freeVoiceChannel(voices []*poolVoice, busy [][]bool, pos, home int) int {
+	if voices[home] == nil && !busy[home][pos] {
+		return home
+	}
+	for ch := range voices {
+		if voices[ch] == nil && !busy[ch][pos] {
+			return ch
+		}
+	}
+	return -1
+}
+
+// stealVoice picks the pooled voice a new note takes over, or -1
+func stealVoice(voices []*poolVoice, note *PoolNote) int {
+	victim := -1
+	for ch, voice := range voices {
+		if voice == nil || voice.since == note.Start {
+			continue
+		}
+		if note.Setting.Pool == PoolStealVolume {
+			if voice.note.Velocity < note.Velocity && (victim < 0 || voice.note.Velocity < voices[victim].note.Velocity) {
+				victim = ch
+			}
+		} else if victim < 0 || voice.since < voices[victim].since {
+			victim = ch
+		}
+	}
+	return victim
+}
+
+func stillSounding(notes []*PoolNote, pos int) []*PoolNote {
+	var sounding []*PoolNote
+	for _, note := range notes {
+		if note.End > pos {
+			sounding = append(sounding, note)
+		}
+	}
+	return sounding
+}
+
+func removePoolNote(notes []*PoolNote, note *PoolNote) []*PoolNote {
+	for i, n := range notes {
+		if n == note {
+			return append(notes[:i:i], notes[i+1:]...)
+		}
+	}
+	return notes
+}
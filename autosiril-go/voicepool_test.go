@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// allocateMapping mixes notes with a mapping, allocates its pooled voices
+// and renders all three AY channels
+func allocateMapping(t *testing.T, mapping string, notes []*VirtualNote, rows int) []string {
+	t.Helper()
+	channels, err := parseChannelMapping(mapping)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	config := newTestConfig(t)
+	pp := NewPolyphonicProcessor(config)
+	timelines, ornamentGen, err := pp.FlattenNotes(notes, rows, channels)
+	if err != nil {
+		t.Fatalf("%q: %v", mapping, err)
+	}
+	pool := pp.CollectPool(notes, channels)
+	cm := NewChannelMixer(config, ornamentGen)
+	ay := cm.MixChannels(timelines, channels, nil)
+	cm.AllocateVoices(ay, pool)
+	rendered := make([]string, len(ay))
+	for i, channel := range ay {
+		rendered[i] = renderMix(channel)
+	}
+	return rendered
+}
+
+func TestAllocateVoices(t *testing.T) {
+	chord := func(start, off int, keys ...int) []*VirtualNote {
+		var notes []*VirtualNote
+		for i, key := range keys {
+			notes = append(notes, &VirtualNote{Note: key, Volume: 15, Velocity: 100 - i, Start: start, Off: off, Length: off - start, Channel: 2})
+		}
+		return notes
+	}
+	tests := []struct {
+		name    string
+		mapping string
+		notes   []*VirtualNote
+		want    []string
+	}{
+		{"idle channels", "1m,2p{pool},3m", chord(0, 4, 60, 64, 67), []string{"0:s64 4:r0", "0:s67 4:r0", "0:s60 4:r0"}},
+		{"busy channel", "1m,2p{pool},3m",
+			append(chord(0, 4, 60, 64), &VirtualNote{Note: 48, Volume: 15, Start: 0, Off: 8, Length: 8, Channel: 1}),
+			[]string{"0:s48 7:r48", "0:s64 4:r0", "0:s60 4:r0"}},
+		{"displaced voice", "1m,2p{pool},3m",
+			append(chord(0, 6, 60, 64, 67), &VirtualNote{Note: 48, Volume: 15, Start: 2, Off: 4, Length: 2, Channel: 3}),
+			[]string{"0:s64 6:r0", "0:s67 2:o67@2 3:o67 6:r0", "0:s60 2:s48 3:s60 6:r0"}},
+		{"steal by age", "1m,2p{pool},3m", append(chord(0, 8, 60, 64, 67), chord(2, 4, 72)...), []string{"0:s64 2:s72 4:s64 8:r0", "0:s67 2:o67@3 4:o67 8:r0", "0:s60 8:r0"}},
+	}
+	for _, tt := range tests {
+		got := allocateMapping(t, tt.mapping, tt.notes, 8)
+		if strings.Join(got, " | ") != strings.Join(tt.want, " | ") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStealVoice(t *testing.T) {
+	voice := func(velocity, since int) *poolVoice {
+		return &poolVoice{note: &PoolNote{Velocity: velocity}, since: since}
+	}
+	tests := []struct {
+		policy   string
+		velocity int
+		voices   []*poolVoice
+		want     int
+	}{
+		{PoolStealAge, 100, []*poolVoice{voice(90, 2), voice(50, 1), voice(70, 3)}, 1},
+		{PoolStealAge, 100, []*poolVoice{nil, voice(50, 4), nil}, -1}, // started with the note
+		{PoolStealVolume, 80, []*poolVoice{voice(90, 2), voice(60, 1), voice(40, 3)}, 2},
+		{PoolStealVolume, 30, []*poolVoice{voice(90, 2), voice(60, 1), voice(40, 3)}, -1},
+	}
+	for _, tt := range tests {
+		note := &PoolNote{Velocity: tt.velocity, Start: 4, Setting: &ChannelSettings{Pool: tt.policy}}
+		if got := stealVoice(tt.voices, note); got != tt.want {
+			t.Errorf("%s velocity %d: stole channel %d, want %d", tt.policy, tt.velocity, got, tt.want)
+		}
+	}
+}
+
+func TestCollectPool(t *testing.T) {
+	channels, err := parseChannelMapping("1m,2p{pool}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []*VirtualNote{
+		{Note: 60, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 2},
+		{Note: 67, Volume: 15, Start: 0, Off: 2, Length: 2, Channel: 2},
+		{Note: 48, Volume: 15, Start: 0, Off: 4, Length: 4, Channel: 1},
+	}
+	tests := []struct {
+		skip int
+		want string
+	}{
+		{0, "67:0-2 60:0-4"},
+		{3, "67:3-5 60:3-7"},
+	}
+	for _, tt := range tests {
+		config := newTestConfig(t)
+		config.SkipLines = tt.skip
+		var got []string
+		for _, note := range NewPolyphonicProcessor(config).CollectPool(notes, channels) {
+			if note.Home != 1 {
+				t.Errorf("skip %d: note %d has home channel %d, want 1", tt.skip, note.Note, note.Home)
+			}
+			got = append(got, fmt.Sprintf("%d:%d-%d", note.Note, note.Start, note.End))
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("skip %d: got %q, want %q", tt.skip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import "sort"
+
+// Chord voicings selected with "{voicing=...}" on "p" channels
+const (
+	VoicingRoot    = "root"    // root position from the detected chord root
+	VoicingClosest = "closest" // inversion whose base is closest to the previous chord
+)
+
+// chordTemplates are pitch class sets in root position, in matching order
+var chordTemplates = [][]int{
+	{0, 4, 7},     // major
+	{0, 3, 7},     // minor
+	{0, 3, 6},     // diminished
+	{0, 4, 8},     // augmented
+	{0, 5, 7},     // sus4
+	{0, 4, 7, 10}, // dominant 7th
+	{0, 4, 7, 11}, // major 7th
+	{0, 3, 7, 10}, // minor 7th
+	{0, 3, 6, 10}, // half-diminished
+	{0, 3, 6, 9},  // diminished 7th
+	{0, 7},        // power chord
+}
+
+// chordRoot detects the root pitch class of a pitch class set. Exact
+// template matches win, then templates containing the set (chords with an
+// omitted tone), then the rotation with the smallest span.
+func chordRoot(classes []int) int {
+	for _, exact := range []bool{true, false} {
+		for _, template := range chordTemplates {
+			for _, root := range classes {
+				if matchesTemplate(classes, template, root, exact) {
+					return root
+				}
+			}
+		}
+	}
+
+	bestRoot, bestSpan := classes[0], 12
+	for _, root := range classes {
+		offsets := classOffsets(classes, root)
+		if span := offsets[len(offsets)-1]; span < bestSpan {
+			bestRoot, bestSpan = root, span
+		}
+	}
+	return bestRoot
+}
+
+func matchesTemplate(classes, template []int, root int, exact bool) bool {
+	if exact && len(classes) != len(template) {
+		return false
+	}
+	inTemplate := make(map[int]bool)
+	for _, t := range template {
+		inTemplate[t] = true
+	}
+	for _, offset := range classOffsets(classes, root) {
+		if !inTemplate[offset] {
+			return false
+		}
+	}
+	return true
+}
+
+// classOffsets returns the classes relative to root, sorted within one octave
+func classOffsets(classes []int, root int) []int {
+	offsets := make([]int, len(classes))
+	for i, c := range classes {
+		offsets[i] = ((c-root)%12 + 12) % 12
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// voiceChord canonicalizes chord notes by pitch class. It returns the base
+// note and ornament offsets: the chord in root position placed at or below
+// the lowest sounding note, or with VoicingClosest the inversion whose base
+// note is nearest to prevBase.
+func voiceChord(chordNotes []int, voicing string, prevBase int) (int, []int) {
+	lowest := chordNotes[0]
+	for _, note := range chordNotes {
+		if note < lowest {
+			lowest = note
+		}
+	}
+	classes := pitchClasses(chordNotes)
+
+	// placeBelow puts a pitch class at or just below the lowest note
+	placeBelow := func(class int) int {
+		note := lowest - ((lowest-class)%12+12)%12
+		if note < 0 {
+			note += 12
+		}
+		return note
+	}
+
+	root := chordRoot(classes)
+	base := placeBelow(root)
+	if voicing == VoicingClosest && prevBase >= 0 {
+		bestDist := -1
+		for _, class := range classes {
+			// The candidate base in the octave nearest to the previous base
+			candidate := prevBase + (((class-prevBase)%12)+12)%12
+			if candidate-prevBase > 6 && candidate >= 12 {
+				candidate -= 12
+			}
+			dist := abs(candidate - prevBase)
+			if bestDist < 0 || dist < bestDist || (dist == bestDist && class == root) {
+				bestDist = dist
+				base = candidate
+			}
+		}
+	}
+	return base, classOffsets(classes, base%12)
+}
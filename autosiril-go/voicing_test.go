@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChordRoot(t *testing.T) {
+	tests := []struct {
+		classes []int
+		want    int
+	}{
+		{[]int{0, 4, 7}, 0},     // C major
+		{[]int{0, 4, 9}, 9},     // A minor
+		{[]int{2, 5, 7, 11}, 7}, // G7
+		{[]int{0, 7}, 0},        // power chord
+		{[]int{0, 4}, 0},        // major with omitted fifth
+		{[]int{0, 1, 2}, 0},     // no template, smallest span
+	}
+	for _, tt := range tests {
+		if got := chordRoot(tt.classes); got != tt.want {
+			t.Errorf("chordRoot(%v) = %d, want %d", tt.classes, got, tt.want)
+		}
+	}
+}
+
+func TestVoiceChord(t *testing.T) {
+	tests := []struct {
+		notes    []int
+		voicing  string
+		prevBase int
+		base     int
+		offsets  string
+	}{
+		{[]int{60, 64, 67}, VoicingRoot, -1, 60, "[0 4 7]"},
+		{[]int{64, 67, 72}, VoicingRoot, -1, 60, "[0 4 7]"},
+		{[]int{67, 72, 76}, VoicingRoot, -1, 60, "[0 4 7]"},
+		{[]int{57, 60, 64}, VoicingRoot, -1, 57, "[0 3 7]"},
+		{[]int{60, 64, 67}, VoicingClosest, -1, 60, "[0 4 7]"},
+		{[]int{60, 64, 67}, VoicingClosest, 65, 64, "[0 3 8]"},
+		{[]int{60, 64, 67}, VoicingClosest, 60, 60, "[0 4 7]"},
+	}
+	for _, tt := range tests {
+		base, offsets := voiceChord(tt.notes, tt.voicing, tt.prevBase)
+		if base != tt.base || fmt.Sprint(offsets) != tt.offsets {
+			t.Errorf("voiceChord(%v, %s, %d) = %d %v, want %d %s", tt.notes, tt.voicing, tt.prevBase, base, offsets, tt.base, tt.offsets)
+		}
+	}
+}
+
+func TestVoicingSharesOrnaments(t *testing.T) {
+	inversions := [][]int{{60, 64, 67}, {64, 67, 72}, {67, 72, 76}}
+	tests := []struct {
+		mapping string
+		want    int
+	}{
+		{"1p", 3},
+		{"1p{voicing=root}", 1},
+	}
+	for _, tt := range tests {
+		channels, err := parseChannelMapping(tt.mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		og := NewOrnamentGenerator(newTestConfig(t))
+		ids := make(map[int]bool)
+		for _, chord := range inversions {
+			_, id := og.ProcessChordNotes(append([]int(nil), chord...), &channels[0][0], -1)
+			ids[id] = true
+		}
+		if len(ids) != tt.want {
+			t.Errorf("%q: %d ornaments for three inversions, want %d", tt.mapping, len(ids), tt.want)
+		}
+	}
+}